@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"github.com/gofrs/uuid"
+	"github.com/golang-jwt/jwt/v5"
+	"strings"
+)
+
+type JWTConfig struct {
+	// Secret enables HS256, JWKSFile enables RS256 and EdDSA.
+	Secret        string
+	JWKSFile      string
+	Issuer        string
+	Audience      string
+	UsernameClaim string
+	ScopeClaim    string
+	// RoleClaim holds a role name or a list of them, tokens without a
+	// known role get DefaultRole.
+	RoleClaim   string
+	DefaultRole string
+}
+
+// JWTVerifier validates access tokens and maps their claims to a principal.
+// Every algorithm is tied to its kind of key, so a token can't pick an
+// algorithm the configured keys weren't meant for.
+type JWTVerifier struct {
+	secret  []byte
+	keys    map[string]crypto.PublicKey
+	parser  *jwt.Parser
+	config  JWTConfig
+	methods []string
+}
+
+var ErrNoJWTKeys = errors.New("jwt auth needs a secret or a jwks file")
+
+func NewJWTVerifier(c JWTConfig) (*JWTVerifier, error) {
+	v := &JWTVerifier{config: c}
+	if c.Secret != "" {
+		v.secret = []byte(c.Secret)
+		v.methods = append(v.methods, jwt.SigningMethodHS256.Alg())
+	}
+	if c.JWKSFile != "" {
+		keys, err := LoadJWKS(c.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		v.keys = keys
+		v.methods = append(v.methods, jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg())
+	}
+	if len(v.methods) == 0 {
+		return nil, ErrNoJWTKeys
+	}
+	if v.config.UsernameClaim == "" {
+		v.config.UsernameClaim = "preferred_username"
+	}
+	if v.config.ScopeClaim == "" {
+		v.config.ScopeClaim = "scope"
+	}
+	if v.config.RoleClaim == "" {
+		v.config.RoleClaim = "role"
+	}
+	if v.config.DefaultRole == "" {
+		v.config.DefaultRole = RoleReader
+	}
+	if !ValidRole(v.config.DefaultRole) {
+		return nil, fmt.Errorf("unknown default role %q", v.config.DefaultRole)
+	}
+
+	opts := []jwt.ParserOption{jwt.WithValidMethods(v.methods), jwt.WithExpirationRequired()}
+	if c.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(c.Issuer))
+	}
+	if c.Audience != "" {
+		opts = append(opts, jwt.WithAudience(c.Audience))
+	}
+	v.parser = jwt.NewParser(opts...)
+	return v, nil
+}
+
+func (v *JWTVerifier) key(t *jwt.Token) (interface{}, error) {
+	switch t.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		return v.secret, nil
+	case *jwt.SigningMethodRSA:
+		key, err := v.publicKey(t)
+		if _, ok := key.(*rsa.PublicKey); err != nil || !ok {
+			return nil, fmt.Errorf("no rsa key for the token")
+		}
+		return key, nil
+	case *jwt.SigningMethodEd25519:
+		key, err := v.publicKey(t)
+		if _, ok := key.(ed25519.PublicKey); err != nil || !ok {
+			return nil, fmt.Errorf("no ed25519 key for the token")
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("unexpected signing method %s", t.Method.Alg())
+}
+
+// publicKey picks the key named by the kid header, a token without kid is
+// accepted only when the JWKS holds a single key.
+func (v *JWTVerifier) publicKey(t *jwt.Token) (crypto.PublicKey, error) {
+	if kid, ok := t.Header["kid"].(string); ok {
+		if key, ok := v.keys[kid]; ok {
+			return key, nil
+		}
+		return nil, fmt.Errorf("unknown kid %q", kid)
+	}
+	if len(v.keys) == 1 {
+		for _, key := range v.keys {
+			return key, nil
+		}
+	}
+	return nil, errors.New("token has no kid")
+}
+
+// Verify checks the token and returns its principal. The user ID is the sub
+// claim when it is a UUID, otherwise a UUID derived from issuer and subject,
+// so the same identity always maps to the same user.
+func (v *JWTVerifier) Verify(token string) (*Principal, error) {
+	claims := jwt.MapClaims{}
+	if _, err := v.parser.ParseWithClaims(token, claims, v.key); err != nil {
+		return nil, err
+	}
+
+	sub, err := claims.GetSubject()
+	if err != nil || sub == "" {
+		return nil, errors.New("token has no subject")
+	}
+	iss, _ := claims.GetIssuer()
+	uid, err := uuid.FromString(sub)
+	if err != nil {
+		uid = uuid.NewV5(uuid.NamespaceURL, iss+"#"+sub)
+	}
+
+	p := &Principal{UserID: uid, Username: sub, Role: v.role(claims[v.config.RoleClaim])}
+	if name, ok := claims[v.config.UsernameClaim].(string); ok && name != "" {
+		p.Username = name
+	}
+	switch scopes := claims[v.config.ScopeClaim].(type) {
+	case string:
+		p.Scopes = strings.Fields(scopes)
+	case []interface{}:
+		p.Scopes = []string{}
+		for _, s := range scopes {
+			if s, ok := s.(string); ok {
+				p.Scopes = append(p.Scopes, s)
+			}
+		}
+	}
+	return p, nil
+}
+
+// role picks the strongest known role out of the role claim.
+func (v *JWTVerifier) role(claim interface{}) string {
+	var roles []string
+	switch claim := claim.(type) {
+	case string:
+		roles = []string{claim}
+	case []interface{}:
+		for _, r := range claim {
+			if r, ok := r.(string); ok {
+				roles = append(roles, r)
+			}
+		}
+	}
+	for _, strongest := range []string{RoleAdmin, RoleEditor, RoleReader} {
+		for _, r := range roles {
+			if r == strongest {
+				return r
+			}
+		}
+	}
+	return v.config.DefaultRole
+}
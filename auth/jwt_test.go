@@ -0,0 +1,175 @@
+package auth_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/gofrs/uuid"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pimka/go-onenote/auth"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func sign(t *testing.T, method jwt.SigningMethod, key interface{}, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func claims(extra jwt.MapClaims) jwt.MapClaims {
+	c := jwt.MapClaims{
+		"sub": "pupa",
+		"iss": "https://id.example.com",
+		"aud": "onenote",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	for k, v := range extra {
+		c[k] = v
+	}
+	return c
+}
+
+func TestJWTVerifier_HS256(t *testing.T) {
+	v, err := auth.NewJWTVerifier(auth.JWTConfig{Secret: "lupa-pupa", Issuer: "https://id.example.com", Audience: "onenote"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := v.Verify(sign(t, jwt.SigningMethodHS256, []byte("lupa-pupa"), "", claims(jwt.MapClaims{
+		"preferred_username": "Pupa",
+		"scope":              "notes:read notes:write",
+	})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Username != "Pupa" || len(p.Scopes) != 2 || !p.HasScope(auth.ScopeNotesWrite) || p.HasScope(auth.ScopeAdmin) {
+		t.Fatalf("claims mapped to a wrong principal %+v", p)
+	}
+	again, _ := v.Verify(sign(t, jwt.SigningMethodHS256, []byte("lupa-pupa"), "", claims(nil)))
+	if again == nil || again.UserID != p.UserID || again.Username != "pupa" || again.Scopes != nil {
+		t.Fatal("the same subject mapped to another principal")
+	}
+	if again.Role != auth.RoleReader {
+		t.Fatal("a token without a role didn't get the default role")
+	}
+	for claim, want := range map[interface{}]string{
+		"editor": auth.RoleEditor,
+		"root":   auth.RoleReader,
+	} {
+		p, _ = v.Verify(sign(t, jwt.SigningMethodHS256, []byte("lupa-pupa"), "", claims(jwt.MapClaims{"role": claim})))
+		if p == nil || p.Role != want {
+			t.Errorf("role claim %v mapped to a wrong role", claim)
+		}
+	}
+	p, _ = v.Verify(sign(t, jwt.SigningMethodHS256, []byte("lupa-pupa"), "", claims(jwt.MapClaims{"role": []string{"reader", "admin"}})))
+	if p == nil || p.Role != auth.RoleAdmin {
+		t.Error("a list of roles didn't map to the strongest one")
+	}
+
+	uid, _ := uuid.NewV4()
+	p, err = v.Verify(sign(t, jwt.SigningMethodHS256, []byte("lupa-pupa"), "", claims(jwt.MapClaims{"sub": uid.String()})))
+	if err != nil || p.UserID != uid {
+		t.Fatal("a UUID subject isn't the user ID")
+	}
+
+	for name, token := range map[string]string{
+		"wrong secret":   sign(t, jwt.SigningMethodHS256, []byte("pupa-lupa"), "", claims(nil)),
+		"expired":        sign(t, jwt.SigningMethodHS256, []byte("lupa-pupa"), "", claims(jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()})),
+		"no expiration":  sign(t, jwt.SigningMethodHS256, []byte("lupa-pupa"), "", claims(jwt.MapClaims{"exp": nil})),
+		"wrong audience": sign(t, jwt.SigningMethodHS256, []byte("lupa-pupa"), "", claims(jwt.MapClaims{"aud": "other"})),
+		"wrong issuer":   sign(t, jwt.SigningMethodHS256, []byte("lupa-pupa"), "", claims(jwt.MapClaims{"iss": "https://evil.example.com"})),
+		"no subject":     sign(t, jwt.SigningMethodHS256, []byte("lupa-pupa"), "", claims(jwt.MapClaims{"sub": ""})),
+		"alg none":       sign(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "", claims(nil)),
+		"garbage":        "not.a.jwt",
+	} {
+		if _, err := v.Verify(token); err == nil {
+			t.Errorf("Verify accepted a token with %s", name)
+		}
+	}
+}
+
+func writeJWKS(t *testing.T, keys ...map[string]string) string {
+	t.Helper()
+	data, err := json.Marshal(map[string]interface{}{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err = os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestJWTVerifier_JWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b64 := base64.RawURLEncoding.EncodeToString
+	path := writeJWKS(t,
+		map[string]string{"kty": "RSA", "kid": "rsa-1", "use": "sig",
+			"n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		map[string]string{"kty": "OKP", "kid": "ed-1", "crv": "Ed25519", "x": b64(edPub)},
+		map[string]string{"kty": "EC", "kid": "ec-1", "crv": "P-256"},
+	)
+
+	v, err := auth.NewJWTVerifier(auth.JWTConfig{JWKSFile: path, ScopeClaim: "scp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := v.Verify(sign(t, jwt.SigningMethodRS256, rsaKey, "rsa-1", claims(jwt.MapClaims{"scp": []string{"notes:read"}})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Scopes) != 1 || !p.HasScope(auth.ScopeNotesRead) {
+		t.Fatal("scp claim wasn't mapped to scopes")
+	}
+	if _, err = v.Verify(sign(t, jwt.SigningMethodEdDSA, edKey, "ed-1", claims(nil))); err != nil {
+		t.Fatal(err)
+	}
+
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	for name, token := range map[string]string{
+		"unknown key":    sign(t, jwt.SigningMethodRS256, otherKey, "rsa-1", claims(nil)),
+		"unknown kid":    sign(t, jwt.SigningMethodRS256, rsaKey, "rsa-2", claims(nil)),
+		"no kid":         sign(t, jwt.SigningMethodRS256, rsaKey, "", claims(nil)),
+		"key mismatch":   sign(t, jwt.SigningMethodEdDSA, edKey, "rsa-1", claims(nil)),
+		"no hmac secret": sign(t, jwt.SigningMethodHS256, []byte(""), "rsa-1", claims(nil)),
+	} {
+		if _, err := v.Verify(token); err == nil {
+			t.Errorf("Verify accepted a token with %s", name)
+		}
+	}
+}
+
+func TestNewJWTVerifier(t *testing.T) {
+	if _, err := auth.NewJWTVerifier(auth.JWTConfig{}); err != auth.ErrNoJWTKeys {
+		t.Error("NewJWTVerifier accepted a config without keys")
+	}
+	if _, err := auth.NewJWTVerifier(auth.JWTConfig{JWKSFile: writeJWKS(t)}); err == nil {
+		t.Error("NewJWTVerifier accepted an empty JWKS")
+	}
+	if _, err := auth.NewJWTVerifier(auth.JWTConfig{JWKSFile: writeJWKS(t,
+		map[string]string{"kty": "RSA", "kid": "weak", "n": "AQAB", "e": "AQAB"})}); err == nil {
+		t.Error("NewJWTVerifier accepted a weak RSA key")
+	}
+}
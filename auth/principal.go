@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"context"
+	"github.com/gofrs/uuid"
+)
+
+const (
+	ScopeNotesRead  = "notes:read"
+	ScopeNotesWrite = "notes:write"
+	ScopeAdmin      = "admin"
+)
+
+func ValidScope(scope string) bool {
+	switch scope {
+	case ScopeNotesRead, ScopeNotesWrite, ScopeAdmin:
+		return true
+	}
+	return false
+}
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	UserID   uuid.UUID
+	Username string
+	// Role decides which routes the caller may reach.
+	Role string
+	// Scopes limit what a caller signed in with an access token may do, nil
+	// means a password sign-in that may do everything.
+	Scopes []string
+}
+
+// HasScope reports whether p may act within scope, admin covers every
+// scope.
+func (p *Principal) HasScope(scope string) bool {
+	if p == nil {
+		return false
+	}
+	if p.Scopes == nil {
+		return true
+	}
+	for _, s := range p.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// ID returns the user ID of p, uuid.Nil for anonymous callers.
+func (p *Principal) ID() uuid.UUID {
+	if p == nil {
+		return uuid.Nil
+	}
+	return p.UserID
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal of the request, nil for anonymous
+// callers.
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
@@ -0,0 +1,75 @@
+package auth
+
+// Permission is an action a role may be granted.
+type Permission string
+
+const (
+	// PermNotesGet reads a shared note by its token, popping and peeking
+	// included.
+	PermNotesGet    Permission = "notes.get"
+	PermNotesList   Permission = "notes.list"
+	PermNotesCreate Permission = "notes.create"
+	PermNotesUpdate Permission = "notes.update"
+	PermNotesDelete Permission = "notes.delete"
+	// PermNotesShare mints and revokes share links to the caller's notes.
+	PermNotesShare   Permission = "notes.share"
+	PermNotesListAll Permission = "notes.list_all"
+	PermNotesPurge   Permission = "notes.purge"
+	PermUsersManage  Permission = "users.manage"
+	PermTokensManage Permission = "tokens.manage"
+)
+
+const (
+	RoleAdmin  = "admin"
+	RoleEditor = "editor"
+	RoleReader = "reader"
+)
+
+var rolePermissions = map[string][]Permission{
+	RoleAdmin: {
+		PermNotesGet, PermNotesList, PermNotesCreate, PermNotesUpdate, PermNotesDelete, PermNotesShare,
+		PermNotesListAll, PermNotesPurge, PermUsersManage, PermTokensManage,
+	},
+	RoleEditor: {PermNotesGet, PermNotesList, PermNotesCreate, PermNotesUpdate, PermNotesDelete, PermNotesShare, PermTokensManage},
+	RoleReader: {PermNotesGet, PermTokensManage},
+}
+
+// permissionScopes is the access token scope each permission needs on top
+// of the role.
+var permissionScopes = map[Permission]string{
+	PermNotesGet:     ScopeNotesRead,
+	PermNotesList:    ScopeNotesRead,
+	PermNotesCreate:  ScopeNotesWrite,
+	PermNotesUpdate:  ScopeNotesWrite,
+	PermNotesDelete:  ScopeNotesWrite,
+	PermNotesShare:   ScopeNotesWrite,
+	PermNotesListAll: ScopeAdmin,
+	PermNotesPurge:   ScopeAdmin,
+	PermUsersManage:  ScopeAdmin,
+	PermTokensManage: ScopeAdmin,
+}
+
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// RoleHas reports whether role grants perm, unknown roles grant nothing.
+func RoleHas(role string, perm Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// Can reports whether p may perform perm. The role decides what the user may
+// do at all, the scopes of an access token can only narrow it down.
+func (p *Principal) Can(perm Permission) bool {
+	if p == nil || !RoleHas(p.Role, perm) {
+		return false
+	}
+	scope, ok := permissionScopes[perm]
+	return ok && p.HasScope(scope)
+}
@@ -0,0 +1,31 @@
+package auth_test
+
+import (
+	"github.com/pimka/go-onenote/auth"
+	"testing"
+)
+
+func TestPrincipal_Can(t *testing.T) {
+	for _, c := range []struct {
+		principal *auth.Principal
+		perm      auth.Permission
+		want      bool
+	}{
+		{&auth.Principal{Role: auth.RoleAdmin}, auth.PermNotesPurge, true},
+		{&auth.Principal{Role: auth.RoleAdmin, Scopes: []string{auth.ScopeNotesRead}}, auth.PermNotesPurge, false},
+		{&auth.Principal{Role: auth.RoleEditor}, auth.PermNotesCreate, true},
+		{&auth.Principal{Role: auth.RoleEditor}, auth.PermNotesListAll, false},
+		{&auth.Principal{Role: auth.RoleEditor, Scopes: []string{auth.ScopeAdmin}}, auth.PermNotesPurge, false},
+		{&auth.Principal{Role: auth.RoleEditor, Scopes: []string{auth.ScopeNotesRead}}, auth.PermNotesUpdate, false},
+		{&auth.Principal{Role: auth.RoleReader}, auth.PermNotesGet, true},
+		{&auth.Principal{Role: auth.RoleReader}, auth.PermNotesList, false},
+		{&auth.Principal{Role: auth.RoleReader}, auth.PermNotesCreate, false},
+		{&auth.Principal{Role: auth.RoleReader}, auth.PermNotesShare, false},
+		{&auth.Principal{Role: "root"}, auth.PermNotesGet, false},
+		{nil, auth.PermNotesGet, false},
+	} {
+		if got := c.principal.Can(c.perm); got != c.want {
+			t.Errorf("%+v Can(%s) = %v", c.principal, c.perm, got)
+		}
+	}
+}
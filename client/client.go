@@ -0,0 +1,160 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gofrs/uuid"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client talks to a go-onenote server in end-to-end encrypted mode: texts are
+// sealed before they leave the process and the key only ever travels in the
+// fragment of the share URL, which browsers and HTTP clients never send.
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	// Username and Password are sent as Basic auth when set, reading notes
+	// needs an account. Token, a personal access token, takes precedence.
+	Username string
+	Password string
+	Token    string
+}
+
+type Note struct {
+	ID             uuid.UUID `json:"id"`
+	Text           string    `json:"text"`
+	Expiration     int       `json:"expiration"`
+	MaxViews       int       `json:"max_views,omitempty"`
+	ViewsRemaining *int      `json:"views_remaining,omitempty"`
+	Nonce          string    `json:"nonce,omitempty"`
+	Algorithm      string    `json:"alg,omitempty"`
+	// Token is the access token of the note, only returned on create.
+	Token string `json:"token,omitempty"`
+}
+
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/"), HTTPClient: http.DefaultClient}
+}
+
+// ShareURL builds the link to a note from its access token with the key in
+// the fragment.
+func (c *Client) ShareURL(token string, key []byte) string {
+	return fmt.Sprintf("%s/note/%s#%s", c.BaseURL, token, EncodeKey(key))
+}
+
+// ParseShareURL splits a share link into the note access token and its key.
+func ParseShareURL(link string) (string, []byte, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", nil, err
+	}
+	token := u.Path[strings.LastIndex(u.Path, "/")+1:]
+	if token == "" {
+		return "", nil, errors.New("share link has no token")
+	}
+	key, err := DecodeKey(u.Fragment)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, key, nil
+}
+
+// CreateNote encrypts text under a fresh key, stores the envelope and returns
+// the share URL.
+func (c *Client) CreateNote(ctx context.Context, text string, expiration, maxViews int) (string, error) {
+	key, err := NewKey()
+	if err != nil {
+		return "", err
+	}
+	e, err := Seal(key, text)
+	if err != nil {
+		return "", err
+	}
+
+	var note Note
+	err = c.do(ctx, http.MethodPost, "/note/", Note{
+		Text:       e.Text,
+		Expiration: expiration,
+		MaxViews:   maxViews,
+		Nonce:      e.Nonce,
+		Algorithm:  e.Algorithm,
+	}, http.StatusAccepted, &note)
+	if err != nil {
+		return "", err
+	}
+	return c.ShareURL(note.Token, key), nil
+}
+
+// ReadNote fetches the note behind a share URL and decrypts it. Reading
+// counts as a view on the server.
+func (c *Client) ReadNote(ctx context.Context, link string) (string, error) {
+	token, key, err := ParseShareURL(link)
+	if err != nil {
+		return "", err
+	}
+	var note Note
+	if err = c.do(ctx, http.MethodGet, "/note/"+token, nil, http.StatusOK, &note); err != nil {
+		return "", err
+	}
+	return Open(key, &Envelope{Text: note.Text, Nonce: note.Nonce, Algorithm: note.Algorithm})
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body interface{}, status int, out interface{}) error {
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, &reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	} else if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return newRateLimitError(resp)
+	}
+	if resp.StatusCode != status {
+		return fmt.Errorf("%s %s: unexpected status %d", method, path, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// RateLimitError is returned when the server turns a request down for going
+// over its rate limit, it shouldn't be retried before RetryAfter.
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limited, retry after %s", e.RetryAfter)
+}
+
+func newRateLimitError(resp *http.Response) *RateLimitError {
+	var body struct {
+		RetryAfter int `json:"retry_after"`
+	}
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil && json.NewDecoder(resp.Body).Decode(&body) == nil {
+		seconds = body.RetryAfter
+	}
+	return &RateLimitError{RetryAfter: time.Duration(seconds) * time.Second}
+}
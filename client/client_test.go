@@ -0,0 +1,154 @@
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/caarlos0/env"
+	"github.com/pimka/go-onenote/client"
+	"github.com/pimka/go-onenote/db"
+	"github.com/pimka/go-onenote/server"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSealOpen(t *testing.T) {
+	key, err := client.NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := client.Seal(key, "top secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(e.Text, "top secret") || e.Algorithm != client.AlgorithmAES256GCM {
+		t.Fatal("SEAL returned plaintext")
+	}
+	text, err := client.Open(key, e)
+	if err != nil || text != "top secret" {
+		t.Fatal("OPEN didn't restore the text")
+	}
+
+	other, _ := client.NewKey()
+	if _, err = client.Open(other, e); err == nil {
+		t.Fatal("OPEN accepted a wrong key")
+	}
+}
+
+// newTestServer serves the real router of a server with the default config
+// and in-memory stores.
+func newTestServer(t *testing.T, rateLimits ...string) (*httptest.Server, *db.MockDB) {
+	var c server.Config
+	if err := env.Parse(&c); err != nil {
+		t.Fatal(err)
+	}
+	c.E2EOnly = true
+	c.RateLimits = rateLimits
+	mdb := db.NewMockDB()
+	s := &server.Server{
+		NH:     mdb,
+		Users:  db.NewMemoryUserDB(),
+		Tokens: db.NewMemoryTokenDB(),
+		Shares: db.NewMemoryShareDB(),
+	}
+	handler, err := s.Handler(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+	return ts, mdb
+}
+
+// post sends body to the server as user and decodes the response into out.
+func post(t *testing.T, ts *httptest.Server, user, path, body string, out interface{}) {
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+path, strings.NewReader(body))
+	if user != "" {
+		req.SetBasicAuth(user, "lupa-pupa")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("POST %s returned %d", path, resp.StatusCode)
+	}
+	if out != nil {
+		json.NewDecoder(resp.Body).Decode(out)
+	}
+}
+
+func TestClient_Roundtrip(t *testing.T) {
+	ts, mdb := newTestServer(t)
+	post(t, ts, "", "/user/register", `{"username":"pupa","password":"lupa-pupa"}`, nil)
+
+	c := client.New(ts.URL)
+	c.Username, c.Password = "pupa", "lupa-pupa"
+	ctx := context.Background()
+	link, err := c.CreateNote(ctx, "top secret", 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = client.ParseShareURL(link); err != nil {
+		t.Fatal(err)
+	}
+	stored := mdb.Notes[len(mdb.Notes)-1]
+	if strings.Contains(stored.Text, "top secret") || stored.Nonce == "" {
+		t.Fatal("server stored plaintext")
+	}
+
+	if _, err = client.New(ts.URL).CreateNote(ctx, "anonymous", 10, 1); err != nil {
+		t.Fatalf("anonymous CREATE refused, %v", err)
+	}
+
+	if _, err = client.New(ts.URL).ReadNote(ctx, link); err == nil {
+		t.Fatal("READ without credentials went through")
+	}
+	text, err := c.ReadNote(ctx, link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "top secret" {
+		t.Fatal("READ returned another text")
+	}
+	if _, err = c.ReadNote(ctx, link); err == nil {
+		t.Fatal("READ returned a burnt note")
+	}
+
+	var pat struct {
+		Token string `json:"token"`
+	}
+	post(t, ts, "pupa", "/user/tokens", `{"name":"ci","scopes":["notes:read"]}`, &pat)
+	if link, err = c.CreateNote(ctx, "for the token", 10, 0); err != nil {
+		t.Fatal(err)
+	}
+	tc := client.New(ts.URL)
+	tc.Token = pat.Token
+	if text, err = tc.ReadNote(ctx, link); err != nil || text != "for the token" {
+		t.Fatalf("READ with an access token failed, %v", err)
+	}
+}
+
+func TestClient_RateLimited(t *testing.T) {
+	ts, _ := newTestServer(t, "notes.create=0.1:1")
+	post(t, ts, "", "/user/register", `{"username":"pupa","password":"lupa-pupa"}`, nil)
+
+	c := client.New(ts.URL)
+	c.Username, c.Password = "pupa", "lupa-pupa"
+	ctx := context.Background()
+	if _, err := c.CreateNote(ctx, "first", 10, 0); err != nil {
+		t.Fatal(err)
+	}
+	_, err := c.CreateNote(ctx, "second", 10, 0)
+	var limited *client.RateLimitError
+	if !errors.As(err, &limited) {
+		t.Fatalf("CREATE past the limit returned %v", err)
+	}
+	if limited.RetryAfter < time.Second || limited.RetryAfter > 10*time.Second {
+		t.Errorf("retry after %s", limited.RetryAfter)
+	}
+}
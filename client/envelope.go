@@ -0,0 +1,104 @@
+package client
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+)
+
+const (
+	// AlgorithmAES256GCM matches server.AlgorithmAES256GCM.
+	AlgorithmAES256GCM = "A256GCM"
+	KeySize            = 32
+)
+
+var (
+	ErrKeySize   = errors.New("key must be 32 bytes")
+	ErrAlgorithm = errors.New("unsupported envelope alg")
+)
+
+// Envelope is the encrypted form of a note text as the server stores it.
+type Envelope struct {
+	Text      string `json:"text"`
+	Nonce     string `json:"nonce"`
+	Algorithm string `json:"alg"`
+}
+
+func NewKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func EncodeKey(key []byte) string {
+	return base64.RawURLEncoding.EncodeToString(key)
+}
+
+func DecodeKey(s string) ([]byte, error) {
+	key, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != KeySize {
+		return nil, ErrKeySize
+	}
+	return key, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, ErrKeySize
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func Seal(key []byte, plaintext string) (*Envelope, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	ct := gcm.Seal(nil, nonce, []byte(plaintext), nil)
+	return &Envelope{
+		Text:      base64.StdEncoding.EncodeToString(ct),
+		Nonce:     base64.StdEncoding.EncodeToString(nonce),
+		Algorithm: AlgorithmAES256GCM,
+	}, nil
+}
+
+func Open(key []byte, e *Envelope) (string, error) {
+	if e.Algorithm != AlgorithmAES256GCM {
+		return "", ErrAlgorithm
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce, err := base64.StdEncoding.DecodeString(e.Nonce)
+	if err != nil {
+		return "", err
+	}
+	ct, err := base64.StdEncoding.DecodeString(e.Text)
+	if err != nil {
+		return "", err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return "", errors.New("invalid nonce size")
+	}
+	pt, err := gcm.Open(nil, nonce, ct, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(pt), nil
+}
@@ -0,0 +1,410 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/gofrs/uuid"
+	bolt "go.etcd.io/bbolt"
+	"time"
+)
+
+var (
+	boltNotesBucket   = []byte("notes")
+	boltCreatedBucket = []byte("created")
+	boltExpiryBucket  = []byte("expiry")
+	boltLeasesBucket  = []byte("leases")
+)
+
+// BoltDB keeps notes in a single bbolt file. Besides the notes themselves it
+// maintains two index buckets keyed by timestamp+id: one by creation time for
+// List and one by deadline, so ClearExpired only walks expired keys. Leases
+// live in a bucket of their own. Every method runs in a single transaction.
+type BoltDB struct {
+	db *bolt.DB
+}
+
+func OpenBolt(path string) (*bolt.DB, error) {
+	bdb, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second * 5})
+	if err != nil {
+		return nil, err
+	}
+
+	err = bdb.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltNotesBucket, boltCreatedBucket, boltExpiryBucket, boltLeasesBucket, boltUsersBucket, boltUsernamesBucket, boltTokensBucket, boltTokenHashesBucket, boltSharesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		bdb.Close()
+		return nil, err
+	}
+	return bdb, nil
+}
+
+func NewBoltDB(bdb *bolt.DB) NoteHandler {
+	return &BoltDB{db: bdb}
+}
+
+func boltIndexKey(t time.Time, uid uuid.UUID) []byte {
+	key := make([]byte, 8+uuid.Size)
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	copy(key[8:], uid.Bytes())
+	return key
+}
+
+func (b *BoltDB) Create(ctx context.Context, n *Note) (*Note, error) {
+	note := newNote(n)
+
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		if old := tx.Bucket(boltNotesBucket).Get(note.ID.Bytes()); old != nil {
+			n, err := decodeBoltNote(old)
+			if err != nil {
+				return err
+			}
+			if err = deleteBoltNote(tx, n); err != nil {
+				return err
+			}
+		}
+		return putBoltNote(tx, note, true)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return note, nil
+}
+
+func (b *BoltDB) Get(ctx context.Context, uid uuid.UUID) (*Note, error) {
+	var note *Note
+	err := b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltNotesBucket).Get(uid.Bytes())
+		if data == nil {
+			return nil
+		}
+		n, err := decodeBoltNote(data)
+		if err != nil || !boltVisible(tx, n, time.Now()) {
+			return err
+		}
+		note = n
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return note, nil
+}
+
+func (b *BoltDB) View(ctx context.Context, uid uuid.UUID) (*Note, error) {
+	var note *Note
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltNotesBucket).Get(uid.Bytes())
+		if data == nil {
+			return nil
+		}
+		n, err := decodeBoltNote(data)
+		if err != nil || !boltVisible(tx, n, time.Now()) {
+			return err
+		}
+		n.countView()
+		note = n
+		if n.Exhausted() {
+			return deleteBoltNote(tx, n)
+		}
+		return putBoltNote(tx, n, false)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return note, nil
+}
+
+func (b *BoltDB) FailAttempt(ctx context.Context, uid uuid.UUID, maxAttempts int) (bool, error) {
+	burnt := false
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltNotesBucket).Get(uid.Bytes())
+		if data == nil {
+			return nil
+		}
+		n, err := decodeBoltNote(data)
+		if err != nil || !boltVisible(tx, n, time.Now()) {
+			return err
+		}
+		n.FailedAttempts++
+		if n.FailedAttempts < maxAttempts {
+			return putBoltNote(tx, n, false)
+		}
+		burnt = true
+		return deleteBoltNote(tx, n)
+	})
+	if err != nil {
+		return false, err
+	}
+	return burnt, nil
+}
+
+func (b *BoltDB) List(ctx context.Context, f ListFilter) ([]*Note, string, error) {
+	lc, err := f.cursor()
+	if err != nil {
+		return nil, "", err
+	}
+
+	size := f.PageSize()
+	now := time.Now()
+	var notes []*Note
+	err = b.db.View(func(tx *bolt.Tx) error {
+		nb := tx.Bucket(boltNotesBucket)
+		c := tx.Bucket(boltCreatedBucket).Cursor()
+
+		var k []byte
+		switch {
+		case lc != nil:
+			k, _ = c.Seek(boltIndexKey(lc.created, lc.id))
+		case !f.CreatedAfter.IsZero():
+			k, _ = c.Seek(boltIndexKey(f.CreatedAfter, uuid.Nil))
+		default:
+			k, _ = c.First()
+		}
+
+		var stop []byte
+		if !f.CreatedBefore.IsZero() {
+			stop = boltIndexKey(f.CreatedBefore, uuid.Nil)
+		}
+		for ; k != nil && len(notes) <= size; k, _ = c.Next() {
+			if stop != nil && bytes.Compare(k, stop) >= 0 {
+				break
+			}
+			data := nb.Get(k[8:])
+			if data == nil {
+				continue
+			}
+			n, err := decodeBoltNote(data)
+			if err != nil {
+				return err
+			}
+			if lc.before(n) && f.match(n, now) && !boltLeased(tx, n.ID, now) {
+				notes = append(notes, n)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	notes, next := f.page(notes)
+	return notes, next, nil
+}
+
+func (b *BoltDB) Update(ctx context.Context, owner uuid.UUID, uid uuid.UUID, content *Note) (*Note, error) {
+	var note *Note
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltNotesBucket).Get(uid.Bytes())
+		if data == nil {
+			return ErrNotFound
+		}
+		n, err := decodeBoltNote(data)
+		if err != nil {
+			return err
+		}
+		if !boltVisible(tx, n, time.Now()) || !n.ownedBy(owner) {
+			return ErrNotFound
+		}
+		n.setContent(content)
+		note = n
+		return putBoltNote(tx, n, false)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return note, nil
+}
+
+func (b *BoltDB) Swap(ctx context.Context, uid uuid.UUID, old *Note, content *Note) (bool, error) {
+	swapped := false
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltNotesBucket).Get(uid.Bytes())
+		if data == nil {
+			return nil
+		}
+		n, err := decodeBoltNote(data)
+		if err != nil {
+			return err
+		}
+		if !boltVisible(tx, n, time.Now()) || !n.sameContent(old) {
+			return nil
+		}
+		n.setContent(content)
+		swapped = true
+		return putBoltNote(tx, n, false)
+	})
+	if err != nil {
+		return false, err
+	}
+	return swapped, nil
+}
+
+func (b *BoltDB) Delete(ctx context.Context, owner uuid.UUID, uid uuid.UUID) (*Note, error) {
+	return b.delete(uid, func(n *Note) bool { return n.ownedBy(owner) })
+}
+
+func (b *BoltDB) Purge(ctx context.Context, uid uuid.UUID) (*Note, error) {
+	return b.delete(uid, func(n *Note) bool { return true })
+}
+
+func (b *BoltDB) delete(uid uuid.UUID, allowed func(n *Note) bool) (*Note, error) {
+	var note *Note
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltNotesBucket).Get(uid.Bytes())
+		if data == nil {
+			return nil
+		}
+		n, err := decodeBoltNote(data)
+		if err != nil || !allowed(n) {
+			return err
+		}
+		if n.visible(time.Now()) {
+			note = n
+		}
+		return deleteBoltNote(tx, n)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return note, nil
+}
+
+func (b *BoltDB) Reserve(ctx context.Context, uid uuid.UUID, lease time.Duration) (*Note, uuid.UUID, error) {
+	token, err := uuid.NewV4()
+	if err != nil {
+		return nil, uuid.Nil, err
+	}
+
+	var note *Note
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltNotesBucket).Get(uid.Bytes())
+		if data == nil {
+			return nil
+		}
+		n, err := decodeBoltNote(data)
+		now := time.Now()
+		if err != nil || !boltVisible(tx, n, now) {
+			return err
+		}
+
+		value := make([]byte, uuid.Size+8)
+		copy(value, token.Bytes())
+		binary.BigEndian.PutUint64(value[uuid.Size:], uint64(now.Add(lease).UnixNano()))
+		note = n
+		return tx.Bucket(boltLeasesBucket).Put(uid.Bytes(), value)
+	})
+	if err != nil || note == nil {
+		return nil, uuid.Nil, err
+	}
+	return note, token, nil
+}
+
+func (b *BoltDB) Ack(ctx context.Context, uid uuid.UUID, token uuid.UUID) (bool, error) {
+	acked := false
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		value := tx.Bucket(boltLeasesBucket).Get(uid.Bytes())
+		if value == nil || !bytes.Equal(value[:uuid.Size], token.Bytes()) || !boltLeased(tx, uid, time.Now()) {
+			return nil
+		}
+		data := tx.Bucket(boltNotesBucket).Get(uid.Bytes())
+		if data == nil {
+			return nil
+		}
+		n, err := decodeBoltNote(data)
+		if err != nil {
+			return err
+		}
+		acked = true
+		return deleteBoltNote(tx, n)
+	})
+	return acked, err
+}
+
+func (b *BoltDB) ClearExpired(ctx context.Context) error {
+	limit := boltIndexKey(time.Now(), uuid.Nil)
+	return b.db.Update(func(tx *bolt.Tx) error {
+		nb := tx.Bucket(boltNotesBucket)
+		cb := tx.Bucket(boltCreatedBucket)
+		c := tx.Bucket(boltExpiryBucket).Cursor()
+		for k, _ := c.First(); k != nil && bytes.Compare(k, limit) < 0; k, _ = c.First() {
+			if data := nb.Get(k[8:]); data != nil {
+				n, err := decodeBoltNote(data)
+				if err != nil {
+					return err
+				}
+				if err = cb.Delete(boltIndexKey(n.Created, n.ID)); err != nil {
+					return err
+				}
+				if err = nb.Delete(k[8:]); err != nil {
+					return err
+				}
+				if err = tx.Bucket(boltLeasesBucket).Delete(k[8:]); err != nil {
+					return err
+				}
+			}
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func putBoltNote(tx *bolt.Tx, n *Note, index bool) error {
+	data, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	if err = tx.Bucket(boltNotesBucket).Put(n.ID.Bytes(), data); err != nil {
+		return err
+	}
+	if !index {
+		return nil
+	}
+	if err = tx.Bucket(boltCreatedBucket).Put(boltIndexKey(n.Created, n.ID), nil); err != nil {
+		return err
+	}
+	return tx.Bucket(boltExpiryBucket).Put(boltIndexKey(n.ExpiresAt(), n.ID), nil)
+}
+
+func boltLeased(tx *bolt.Tx, uid uuid.UUID, now time.Time) bool {
+	value := tx.Bucket(boltLeasesBucket).Get(uid.Bytes())
+	if value == nil {
+		return false
+	}
+	until := int64(binary.BigEndian.Uint64(value[uuid.Size:]))
+	return until > now.UnixNano()
+}
+
+func boltVisible(tx *bolt.Tx, n *Note, now time.Time) bool {
+	return n.visible(now) && !boltLeased(tx, n.ID, now)
+}
+
+func deleteBoltNote(tx *bolt.Tx, n *Note) error {
+	if err := tx.Bucket(boltNotesBucket).Delete(n.ID.Bytes()); err != nil {
+		return err
+	}
+	if err := tx.Bucket(boltLeasesBucket).Delete(n.ID.Bytes()); err != nil {
+		return err
+	}
+	if err := tx.Bucket(boltCreatedBucket).Delete(boltIndexKey(n.Created, n.ID)); err != nil {
+		return err
+	}
+	return tx.Bucket(boltExpiryBucket).Delete(boltIndexKey(n.ExpiresAt(), n.ID))
+}
+
+func decodeBoltNote(data []byte) (*Note, error) {
+	n := &Note{}
+	if err := json.Unmarshal(data, n); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
@@ -0,0 +1,132 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gofrs/uuid"
+	bolt "go.etcd.io/bbolt"
+	"time"
+)
+
+var boltSharesBucket = []byte("shares")
+
+type BoltShareDB struct {
+	db *bolt.DB
+}
+
+func NewBoltShareDB(bdb *bolt.DB) ShareHandler {
+	return &BoltShareDB{db: bdb}
+}
+
+func (b *BoltShareDB) CreateShare(ctx context.Context, l *ShareLink) (*ShareLink, error) {
+	link := newShareLink(l)
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		var expired [][]byte
+		err := tx.Bucket(boltSharesBucket).ForEach(func(k, v []byte) error {
+			old := &ShareLink{}
+			if err := json.Unmarshal(v, old); err != nil {
+				return err
+			}
+			if !old.ExpiresAt.After(link.Created) {
+				expired = append(expired, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err = tx.Bucket(boltSharesBucket).Delete(k); err != nil {
+				return err
+			}
+		}
+		return putBoltShare(tx, link)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+func (b *BoltShareDB) GetShare(ctx context.Context, id uuid.UUID) (*ShareLink, error) {
+	var link *ShareLink
+	err := b.db.View(func(tx *bolt.Tx) error {
+		l, err := getBoltShare(tx, id.Bytes())
+		if err != nil || l == nil || !l.Usable(time.Now()) {
+			return err
+		}
+		link = l
+		return nil
+	})
+	return link, err
+}
+
+// ListShares scans all links, like ListTokens.
+func (b *BoltShareDB) ListShares(ctx context.Context, noteID uuid.UUID) ([]*ShareLink, error) {
+	now := time.Now()
+	var links []*ShareLink
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltSharesBucket).ForEach(func(k, v []byte) error {
+			l := &ShareLink{}
+			if err := json.Unmarshal(v, l); err != nil {
+				return err
+			}
+			if l.NoteID == noteID && l.Usable(now) {
+				links = append(links, l)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortShares(links)
+	return links, nil
+}
+
+func (b *BoltShareDB) UseShare(ctx context.Context, id uuid.UUID) (*ShareLink, error) {
+	var link *ShareLink
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		l, err := getBoltShare(tx, id.Bytes())
+		if err != nil || l == nil || !l.Usable(time.Now()) {
+			return err
+		}
+		l.countView()
+		link = l
+		return putBoltShare(tx, l)
+	})
+	return link, err
+}
+
+func (b *BoltShareDB) RevokeShare(ctx context.Context, noteID, id uuid.UUID) (bool, error) {
+	revoked := false
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		l, err := getBoltShare(tx, id.Bytes())
+		if err != nil || l == nil || l.NoteID != noteID {
+			return err
+		}
+		revoked = true
+		return tx.Bucket(boltSharesBucket).Delete(id.Bytes())
+	})
+	return revoked, err
+}
+
+func putBoltShare(tx *bolt.Tx, l *ShareLink) error {
+	data, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(boltSharesBucket).Put(l.ID.Bytes(), data)
+}
+
+func getBoltShare(tx *bolt.Tx, id []byte) (*ShareLink, error) {
+	data := tx.Bucket(boltSharesBucket).Get(id)
+	if data == nil {
+		return nil, nil
+	}
+	l := &ShareLink{}
+	if err := json.Unmarshal(data, l); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
@@ -0,0 +1,59 @@
+package db_test
+
+import (
+	"context"
+	"github.com/gofrs/uuid"
+	"github.com/pimka/go-onenote/db"
+	"path/filepath"
+	"testing"
+)
+
+func TestBoltDB(t *testing.T) {
+	conf := db.Config{Driver: db.DriverBolt, BoltPath: filepath.Join(t.TempDir(), "notes.bolt")}
+	storage, err := db.Open(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	nh := storage.Notes
+
+	testNoteHandler(t, nh)
+	testNoteHandlerPages(t, nh)
+	testNoteHandlerViews(t, nh)
+	testNoteHandlerAttempts(t, nh)
+	testNoteHandlerOwners(t, nh)
+	testNoteHandlerSwap(t, nh)
+	testNoteHandlerLeases(t, nh)
+	testUserHandler(t, storage.Users)
+	testTokenHandler(t, storage.Tokens, storage.Users)
+	testShareHandler(t, storage.Shares, storage.Notes)
+}
+
+func TestBoltDB_Reopen(t *testing.T) {
+	conf := db.Config{Driver: db.DriverBolt, BoltPath: filepath.Join(t.TempDir(), "notes.bolt")}
+	ctx := context.Background()
+
+	storage, err := db.Open(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid, _ := uuid.NewV4()
+	if _, err = storage.Notes.Create(ctx, &db.Note{ID: uid, Text: "test", Expiration: 10}); err != nil {
+		t.Fatal(err)
+	}
+	storage.Close()
+
+	storage, err = db.Open(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	n, err := storage.Notes.Get(ctx, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n == nil || n.Text != "test" {
+		t.Fatal("note is lost after reopen")
+	}
+}
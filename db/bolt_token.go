@@ -0,0 +1,119 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gofrs/uuid"
+	bolt "go.etcd.io/bbolt"
+	"time"
+)
+
+var (
+	boltTokensBucket      = []byte("tokens")
+	boltTokenHashesBucket = []byte("token_hashes")
+)
+
+type BoltTokenDB struct {
+	db *bolt.DB
+}
+
+func NewBoltTokenDB(bdb *bolt.DB) TokenHandler {
+	return &BoltTokenDB{db: bdb}
+}
+
+func (b *BoltTokenDB) CreateToken(ctx context.Context, t *AccessToken) (*AccessToken, error) {
+	token := newAccessToken(t)
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(boltTokenHashesBucket).Put([]byte(token.Hash), token.ID.Bytes()); err != nil {
+			return err
+		}
+		return putBoltToken(tx, token)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func (b *BoltTokenDB) GetTokenByHash(ctx context.Context, hash string) (*AccessToken, error) {
+	var token *AccessToken
+	err := b.db.View(func(tx *bolt.Tx) error {
+		id := tx.Bucket(boltTokenHashesBucket).Get([]byte(hash))
+		if id == nil {
+			return nil
+		}
+		var err error
+		token, err = getBoltToken(tx, id)
+		return err
+	})
+	return token, err
+}
+
+// ListTokens scans all tokens, an edge box holds a handful of them.
+func (b *BoltTokenDB) ListTokens(ctx context.Context, userID uuid.UUID) ([]*AccessToken, error) {
+	var tokens []*AccessToken
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltTokensBucket).ForEach(func(k, v []byte) error {
+			t := &AccessToken{}
+			if err := json.Unmarshal(v, t); err != nil {
+				return err
+			}
+			if t.UserID == userID {
+				tokens = append(tokens, t)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortTokens(tokens)
+	return tokens, nil
+}
+
+func (b *BoltTokenDB) RevokeToken(ctx context.Context, userID, id uuid.UUID) (bool, error) {
+	revoked := false
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		t, err := getBoltToken(tx, id.Bytes())
+		if err != nil || t == nil || t.UserID != userID {
+			return err
+		}
+		if err = tx.Bucket(boltTokenHashesBucket).Delete([]byte(t.Hash)); err != nil {
+			return err
+		}
+		revoked = true
+		return tx.Bucket(boltTokensBucket).Delete(id.Bytes())
+	})
+	return revoked, err
+}
+
+func (b *BoltTokenDB) TouchToken(ctx context.Context, id uuid.UUID, at time.Time) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		t, err := getBoltToken(tx, id.Bytes())
+		if err != nil || t == nil {
+			return err
+		}
+		t.LastUsed = &at
+		return putBoltToken(tx, t)
+	})
+}
+
+func putBoltToken(tx *bolt.Tx, t *AccessToken) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(boltTokensBucket).Put(t.ID.Bytes(), data)
+}
+
+func getBoltToken(tx *bolt.Tx, id []byte) (*AccessToken, error) {
+	data := tx.Bucket(boltTokensBucket).Get(id)
+	if data == nil {
+		return nil, nil
+	}
+	t := &AccessToken{}
+	if err := json.Unmarshal(data, t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
@@ -0,0 +1,98 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gofrs/uuid"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltUsersBucket     = []byte("users")
+	boltUsernamesBucket = []byte("usernames")
+)
+
+type BoltUserDB struct {
+	db *bolt.DB
+}
+
+func NewBoltUserDB(bdb *bolt.DB) UserHandler {
+	return &BoltUserDB{db: bdb}
+}
+
+func (b *BoltUserDB) CreateUser(ctx context.Context, u *User) (*User, error) {
+	user := newUser(u)
+	data, err := json.Marshal(user)
+	if err != nil {
+		return nil, err
+	}
+
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		names := tx.Bucket(boltUsernamesBucket)
+		if names.Get([]byte(user.Username)) != nil {
+			return ErrUserExists
+		}
+		if err := names.Put([]byte(user.Username), user.ID.Bytes()); err != nil {
+			return err
+		}
+		return tx.Bucket(boltUsersBucket).Put(user.ID.Bytes(), data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (b *BoltUserDB) GetUser(ctx context.Context, uid uuid.UUID) (*User, error) {
+	var user *User
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		user, err = getBoltUser(tx, uid.Bytes())
+		return err
+	})
+	return user, err
+}
+
+func (b *BoltUserDB) GetUserByName(ctx context.Context, username string) (*User, error) {
+	var user *User
+	err := b.db.View(func(tx *bolt.Tx) error {
+		id := tx.Bucket(boltUsernamesBucket).Get([]byte(username))
+		if id == nil {
+			return nil
+		}
+		var err error
+		user, err = getBoltUser(tx, id)
+		return err
+	})
+	return user, err
+}
+
+func (b *BoltUserDB) SetRole(ctx context.Context, uid uuid.UUID, role string) (bool, error) {
+	found := false
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		u, err := getBoltUser(tx, uid.Bytes())
+		if err != nil || u == nil {
+			return err
+		}
+		u.Role = role
+		data, err := json.Marshal(u)
+		if err != nil {
+			return err
+		}
+		found = true
+		return tx.Bucket(boltUsersBucket).Put(uid.Bytes(), data)
+	})
+	return found, err
+}
+
+func getBoltUser(tx *bolt.Tx, id []byte) (*User, error) {
+	data := tx.Bucket(boltUsersBucket).Get(id)
+	if data == nil {
+		return nil, nil
+	}
+	u := &User{}
+	if err := json.Unmarshal(data, u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
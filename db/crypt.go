@@ -0,0 +1,213 @@
+package db
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/gofrs/uuid"
+	"time"
+)
+
+// CryptHandler encrypts notes at rest on top of any NoteHandler. Every note
+// gets its own AES-GCM data key, the text is sealed with it using the note ID
+// as associated data, and the data key is stored wrapped by the current
+// master key together with that key's ID. Notes read back are decrypted and
+// carry no key material.
+type CryptHandler struct {
+	nh   NoteHandler
+	keys *Keyring
+}
+
+func NewCryptHandler(nh NoteHandler, keys *Keyring) *CryptHandler {
+	return &CryptHandler{nh: nh, keys: keys}
+}
+
+// seal returns a copy of content with its text encrypted under a fresh data
+// key.
+func (c *CryptHandler) seal(uid uuid.UUID, content *Note) (*Note, error) {
+	dataKey, err := newDataKey()
+	if err != nil {
+		return nil, err
+	}
+	ct, err := sealGCM(dataKey, []byte(content.Text), uid.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	sealed := content.clone()
+	sealed.Text = base64.StdEncoding.EncodeToString(ct)
+	sealed.KeyID, sealed.DataKey, err = c.keys.wrap(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return sealed, nil
+}
+
+// open returns a decrypted copy of n. Notes stored before encryption was
+// turned on have no key ID and come back as they are.
+func (c *CryptHandler) open(n *Note) (*Note, error) {
+	if n == nil {
+		return nil, nil
+	}
+	opened := n.clone()
+	opened.KeyID, opened.DataKey = "", ""
+	if n.KeyID == "" {
+		return opened, nil
+	}
+	dataKey, err := c.keys.unwrap(n.KeyID, n.DataKey)
+	if err != nil {
+		return nil, err
+	}
+	ct, err := base64.StdEncoding.DecodeString(n.Text)
+	if err != nil {
+		return nil, err
+	}
+	text, err := openGCM(dataKey, ct, n.ID.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	opened.Text = string(text)
+	return opened, nil
+}
+
+func (c *CryptHandler) Create(ctx context.Context, n *Note) (*Note, error) {
+	sealed, err := c.seal(n.ID, n)
+	if err != nil {
+		return nil, err
+	}
+	note, err := c.nh.Create(ctx, sealed)
+	if err != nil {
+		return nil, err
+	}
+	return c.open(note)
+}
+
+func (c *CryptHandler) Get(ctx context.Context, uid uuid.UUID) (*Note, error) {
+	note, err := c.nh.Get(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	return c.open(note)
+}
+
+func (c *CryptHandler) View(ctx context.Context, uid uuid.UUID) (*Note, error) {
+	note, err := c.nh.View(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	return c.open(note)
+}
+
+func (c *CryptHandler) List(ctx context.Context, f ListFilter) ([]*Note, string, error) {
+	notes, next, err := c.nh.List(ctx, f)
+	if err != nil {
+		return nil, "", err
+	}
+	for i, n := range notes {
+		if notes[i], err = c.open(n); err != nil {
+			return nil, "", err
+		}
+	}
+	return notes, next, nil
+}
+
+func (c *CryptHandler) Update(ctx context.Context, owner uuid.UUID, uid uuid.UUID, content *Note) (*Note, error) {
+	sealed, err := c.seal(uid, content)
+	if err != nil {
+		return nil, err
+	}
+	note, err := c.nh.Update(ctx, owner, uid, sealed)
+	if err != nil {
+		return nil, err
+	}
+	return c.open(note)
+}
+
+// Swap passes stored notes through as they are, old and content are sealed.
+func (c *CryptHandler) Swap(ctx context.Context, uid uuid.UUID, old *Note, content *Note) (bool, error) {
+	return c.nh.Swap(ctx, uid, old, content)
+}
+
+func (c *CryptHandler) Delete(ctx context.Context, owner uuid.UUID, uid uuid.UUID) (*Note, error) {
+	note, err := c.nh.Delete(ctx, owner, uid)
+	if err != nil {
+		return nil, err
+	}
+	return c.open(note)
+}
+
+func (c *CryptHandler) Purge(ctx context.Context, uid uuid.UUID) (*Note, error) {
+	note, err := c.nh.Purge(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	return c.open(note)
+}
+
+func (c *CryptHandler) Reserve(ctx context.Context, uid uuid.UUID, lease time.Duration) (*Note, uuid.UUID, error) {
+	note, token, err := c.nh.Reserve(ctx, uid, lease)
+	if err != nil || note == nil {
+		return nil, uuid.Nil, err
+	}
+	note, err = c.open(note)
+	if err != nil {
+		return nil, uuid.Nil, err
+	}
+	return note, token, nil
+}
+
+func (c *CryptHandler) Ack(ctx context.Context, uid uuid.UUID, token uuid.UUID) (bool, error) {
+	return c.nh.Ack(ctx, uid, token)
+}
+
+func (c *CryptHandler) FailAttempt(ctx context.Context, uid uuid.UUID, maxAttempts int) (bool, error) {
+	return c.nh.FailAttempt(ctx, uid, maxAttempts)
+}
+
+func (c *CryptHandler) ClearExpired(ctx context.Context) error {
+	return c.nh.ClearExpired(ctx)
+}
+
+// Rewrap walks all visible notes and re-wraps the data keys of those stored
+// under an old master key, the text itself is left alone. Notes written
+// before encryption was turned on get encrypted. Leased notes are skipped and
+// picked up on the next run, so are notes edited since they were listed.
+func (c *CryptHandler) Rewrap(ctx context.Context) error {
+	f := ListFilter{Limit: MaxPageSize, AllOwners: true}
+	for {
+		notes, next, err := c.nh.List(ctx, f)
+		if err != nil {
+			return err
+		}
+		for _, n := range notes {
+			if n.KeyID == c.keys.Current() {
+				continue
+			}
+			content, err := c.rewrap(n)
+			if err != nil {
+				return err
+			}
+			if _, err = c.nh.Swap(ctx, n.ID, n, content); err != nil {
+				return err
+			}
+		}
+		if next == "" {
+			return nil
+		}
+		f.Cursor = next
+	}
+}
+
+func (c *CryptHandler) rewrap(n *Note) (*Note, error) {
+	if n.KeyID == "" {
+		return c.seal(n.ID, n)
+	}
+	dataKey, err := c.keys.unwrap(n.KeyID, n.DataKey)
+	if err != nil {
+		return nil, err
+	}
+	content := n.clone()
+	content.KeyID, content.DataKey, err = c.keys.wrap(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return content, nil
+}
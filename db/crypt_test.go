@@ -0,0 +1,184 @@
+package db_test
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"github.com/gofrs/uuid"
+	"github.com/pimka/go-onenote/db"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func testKey(t *testing.T) []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestCryptHandler(t *testing.T) {
+	mdb := db.NewMemoryDB()
+	defer mdb.Close()
+	keys, err := db.NewKeyring("k1", map[string][]byte{"k1": testKey(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := db.NewCryptHandler(mdb, keys)
+
+	testNoteHandler(t, ch)
+	testNoteHandlerPages(t, ch)
+	testNoteHandlerViews(t, ch)
+	testNoteHandlerAttempts(t, ch)
+	testNoteHandlerOwners(t, ch)
+	testNoteHandlerLeases(t, ch)
+}
+
+func TestCryptHandler_Rotation(t *testing.T) {
+	ctx := context.Background()
+	mdb := db.NewMemoryDB()
+	defer mdb.Close()
+	k1, k2 := testKey(t), testKey(t)
+
+	legacy, _ := uuid.NewV4()
+	if _, err := mdb.Create(ctx, &db.Note{ID: legacy, Text: "legacy", Expiration: 10}); err != nil {
+		t.Fatal(err)
+	}
+
+	old, _ := db.NewKeyring("k1", map[string][]byte{"k1": k1})
+	uid, _ := uuid.NewV4()
+	note, err := db.NewCryptHandler(mdb, old).Create(ctx, &db.Note{ID: uid, Text: "secret", Expiration: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if note.Text != "secret" || note.KeyID != "" || note.DataKey != "" {
+		t.Fatal("CREATE returned key material or ciphertext")
+	}
+	stored, _ := mdb.Get(ctx, uid)
+	if stored.KeyID != "k1" || strings.Contains(stored.Text, "secret") {
+		t.Fatal("note stored in plaintext")
+	}
+
+	keys, _ := db.NewKeyring("k2", map[string][]byte{"k1": k1, "k2": k2})
+	ch := db.NewCryptHandler(mdb, keys)
+	if n, err := ch.Get(ctx, uid); err != nil || n.Text != "secret" {
+		t.Fatal("GET can't read a note under the old key")
+	}
+	if err = ch.Rewrap(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for id, text := range map[uuid.UUID]string{uid: "secret", legacy: "legacy"} {
+		stored, _ = mdb.Get(ctx, id)
+		if stored.KeyID != "k2" || strings.Contains(stored.Text, text) {
+			t.Fatal("REWRAP left a note under the old key")
+		}
+	}
+
+	rotated, _ := db.NewKeyring("k2", map[string][]byte{"k2": k2})
+	n, err := db.NewCryptHandler(mdb, rotated).Get(ctx, uid)
+	if err != nil || n.Text != "secret" {
+		t.Fatal("GET can't read a re-wrapped note without the old key")
+	}
+}
+
+// editingDB edits a note right after it was listed, the way a user racing a
+// rewrap would.
+type editingDB struct {
+	db.NoteHandler
+	edit func()
+}
+
+func (e *editingDB) List(ctx context.Context, f db.ListFilter) ([]*db.Note, string, error) {
+	notes, next, err := e.NoteHandler.List(ctx, f)
+	if e.edit != nil {
+		e.edit()
+		e.edit = nil
+	}
+	return notes, next, err
+}
+
+func TestCryptHandler_RewrapRace(t *testing.T) {
+	ctx := context.Background()
+	mdb := db.NewMemoryDB()
+	defer mdb.Close()
+	k1, k2 := testKey(t), testKey(t)
+
+	pupa, _ := uuid.NewV4()
+	uid, _ := uuid.NewV4()
+	old, _ := db.NewKeyring("k1", map[string][]byte{"k1": k1})
+	if _, err := db.NewCryptHandler(mdb, old).Create(ctx, &db.Note{ID: uid, OwnerID: pupa, Text: "before", Expiration: 10}); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, _ := db.NewKeyring("k2", map[string][]byte{"k1": k1, "k2": k2})
+	edb := &editingDB{NoteHandler: mdb}
+	ch := db.NewCryptHandler(edb, keys)
+	edb.edit = func() {
+		if _, err := ch.Update(ctx, pupa, uid, &db.Note{Text: "after"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ch.Rewrap(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := ch.Get(ctx, uid); err != nil || n.Text != "after" {
+		t.Fatal("REWRAP reverted a concurrent edit")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		text := fmt.Sprint("edit ", i)
+		go func() {
+			defer wg.Done()
+			if _, err := db.NewCryptHandler(mdb, old).Update(ctx, pupa, uid, &db.Note{Text: text}); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if err := db.NewCryptHandler(mdb, keys).Rewrap(ctx); err != nil {
+				t.Error(err)
+			}
+		}()
+		wg.Wait()
+		if n, err := ch.Get(ctx, uid); err != nil || n.Text != text {
+			t.Fatal("REWRAP reverted a concurrent edit")
+		}
+	}
+}
+
+func TestLoadKeyring(t *testing.T) {
+	keys, err := db.LoadKeyring(db.Config{})
+	if err != nil || keys != nil {
+		t.Fatal("keyring loaded without keys")
+	}
+
+	path := filepath.Join(t.TempDir(), "master.keys")
+	file := "# rotated 2026-01\nk1:" + base64.StdEncoding.EncodeToString(testKey(t)) + "\n"
+	if err = os.WriteFile(path, []byte(file), 0600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err = db.LoadKeyring(db.Config{
+		MasterKeyFile: path,
+		MasterKeys:    []string{"k2:" + base64.StdEncoding.EncodeToString(testKey(t))},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys.Current() != "k2" {
+		t.Fatal("current key isn't the last one listed")
+	}
+
+	if _, err = db.LoadKeyring(db.Config{MasterKeys: []string{"k1:c2hvcnQ="}}); err == nil {
+		t.Fatal("short master key accepted")
+	}
+	if _, err = db.LoadKeyring(db.Config{MasterKeys: []string{"k1:" + base64.StdEncoding.EncodeToString(testKey(t))}, MasterKeyID: "k3"}); err == nil {
+		t.Fatal("unknown current key accepted")
+	}
+}
@@ -0,0 +1,441 @@
+package db_test
+
+import (
+	"context"
+	"github.com/gofrs/uuid"
+	"github.com/pimka/go-onenote/db"
+	"testing"
+	"time"
+)
+
+func testNoteHandler(t *testing.T, nh db.NoteHandler) {
+	ctx := context.Background()
+
+	uid, _ := uuid.NewV4()
+	note, err := nh.Create(ctx, &db.Note{ID: uid, Text: "test message", Expiration: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if note.ID != uid || note.Text != "test message" || note.Expiration != 10 {
+		t.Fatal("CREATE returned another note")
+	}
+
+	n, err := nh.Get(ctx, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n == nil || n.ID != uid || n.Text != note.Text {
+		t.Fatal("GET note.ID != uid")
+	}
+
+	missing, _ := uuid.NewV4()
+	n, err = nh.Get(ctx, missing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != nil {
+		t.Fatal("GET returned a note that doesn't exist")
+	}
+
+	n, err = nh.Update(ctx, uuid.Nil, uid, &db.Note{Text: "memes-pepes"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.ID != uid || n.Text != "memes-pepes" || !n.Created.Equal(note.Created) {
+		t.Fatal("UPDATE returned another note")
+	}
+	if _, err = nh.Update(ctx, uuid.Nil, uid, &db.Note{Text: "Y2lwaGVy", Nonce: "bm9uY2U=", Algorithm: "A256GCM"}); err != nil {
+		t.Fatal(err)
+	}
+	n, err = nh.Get(ctx, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Text != "Y2lwaGVy" || n.Nonce != "bm9uY2U=" || n.Algorithm != "A256GCM" {
+		t.Fatal("UPDATE lost the envelope")
+	}
+
+	expired, _ := uuid.NewV4()
+	if _, err = nh.Create(ctx, &db.Note{ID: expired, Text: "expired"}); err != nil {
+		t.Fatal(err)
+	}
+	n, err = nh.Get(ctx, expired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != nil {
+		t.Fatal("GET returned an expired note")
+	}
+	if _, err = nh.Update(ctx, uuid.Nil, expired, &db.Note{Text: "still here"}); err != db.ErrNotFound {
+		t.Fatal("UPDATE changed an expired note")
+	}
+	notes, _, err := nh.List(ctx, db.ListFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != 1 || notes[0].ID != uid {
+		t.Fatal("LIST returned an expired note")
+	}
+
+	if err = nh.ClearExpired(ctx); err != nil {
+		t.Fatal(err)
+	}
+	notes, _, err = nh.List(ctx, db.ListFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != 1 || notes[0].ID != uid {
+		t.Fatal("CLEAR kept an expired note or dropped a live one")
+	}
+	n, err = nh.Delete(ctx, uuid.Nil, expired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != nil {
+		t.Fatal("DELETE returned an expired note")
+	}
+
+	n, err = nh.Delete(ctx, uuid.Nil, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n == nil || n.ID != uid || n.Text != "Y2lwaGVy" {
+		t.Fatal("DELETE note.ID != uid")
+	}
+	n, err = nh.Delete(ctx, uuid.Nil, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != nil {
+		t.Fatal("DELETE returned a note twice")
+	}
+}
+
+func testNoteHandlerPages(t *testing.T, nh db.NoteHandler) {
+	ctx := context.Background()
+
+	var created []uuid.UUID
+	for i := 0; i < 5; i++ {
+		uid, _ := uuid.NewV4()
+		if _, err := nh.Create(ctx, &db.Note{ID: uid, Text: "page", Expiration: 10 + i*10}); err != nil {
+			t.Fatal(err)
+		}
+		created = append(created, uid)
+	}
+
+	var listed []uuid.UUID
+	filter := db.ListFilter{Limit: 2}
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatal("LIST never ran out of pages")
+		}
+		notes, next, err := nh.List(ctx, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(notes) > 2 {
+			t.Fatal("LIST ignored the limit")
+		}
+		for _, n := range notes {
+			listed = append(listed, n.ID)
+		}
+		if next == "" {
+			break
+		}
+		filter.Cursor = next
+	}
+	if len(listed) != len(created) {
+		t.Fatalf("LIST paged through %d notes, want %d", len(listed), len(created))
+	}
+	for i := range created {
+		if listed[i] != created[i] {
+			t.Fatal("LIST pages are not ordered by created")
+		}
+	}
+
+	notes, _, err := nh.List(ctx, db.ListFilter{ExpiringWithin: time.Minute * 25})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != 2 || notes[0].ID != created[0] || notes[1].ID != created[1] {
+		t.Fatal("LIST ignored expiring_within")
+	}
+
+	notes, _, err = nh.List(ctx, db.ListFilter{CreatedAfter: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != 0 {
+		t.Fatal("LIST ignored created_after")
+	}
+
+	if _, _, err = nh.List(ctx, db.ListFilter{Cursor: "garbage"}); err != db.ErrInvalidCursor {
+		t.Fatal("LIST accepted an invalid cursor")
+	}
+}
+
+func testNoteHandlerViews(t *testing.T, nh db.NoteHandler) {
+	ctx := context.Background()
+
+	uid, _ := uuid.NewV4()
+	note, err := nh.Create(ctx, &db.Note{ID: uid, Text: "burn after reading", Expiration: 10, MaxViews: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if note.MaxViews != 2 || note.ViewsRemaining == nil || *note.ViewsRemaining != 2 {
+		t.Fatal("CREATE ignored max_views")
+	}
+
+	if n, err := nh.Get(ctx, uid); err != nil || n == nil || *n.ViewsRemaining != 2 {
+		t.Fatal("GET counted a view")
+	}
+	for want := 1; want >= 0; want-- {
+		n, err := nh.View(ctx, uid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == nil || n.ViewsRemaining == nil || *n.ViewsRemaining != want {
+			t.Fatalf("VIEW returned a wrong counter, want %d", want)
+		}
+	}
+	if n, err := nh.View(ctx, uid); err != nil || n != nil {
+		t.Fatal("VIEW returned a used up note")
+	}
+	if n, err := nh.Get(ctx, uid); err != nil || n != nil {
+		t.Fatal("GET returned a used up note")
+	}
+
+	unlimited, _ := uuid.NewV4()
+	if _, err = nh.Create(ctx, &db.Note{ID: unlimited, Text: "read me", Expiration: 10}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		n, err := nh.View(ctx, unlimited)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == nil || n.ViewsRemaining != nil {
+			t.Fatal("VIEW limited an unlimited note")
+		}
+	}
+}
+
+func testNoteHandlerLeases(t *testing.T, nh db.NoteHandler) {
+	ctx := context.Background()
+
+	uid, _ := uuid.NewV4()
+	if _, err := nh.Create(ctx, &db.Note{ID: uid, Text: "pop me", Expiration: 10}); err != nil {
+		t.Fatal(err)
+	}
+
+	n, token, err := nh.Reserve(ctx, uid, time.Millisecond*200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n == nil || n.ID != uid || token == uuid.Nil {
+		t.Fatal("RESERVE didn't lease the note")
+	}
+	if n, _ = nh.Get(ctx, uid); n != nil {
+		t.Fatal("GET returned a reserved note")
+	}
+	if n, _, _ = nh.Reserve(ctx, uid, time.Minute); n != nil {
+		t.Fatal("RESERVE leased a note twice")
+	}
+
+	time.Sleep(time.Millisecond * 300)
+	if acked, _ := nh.Ack(ctx, uid, token); acked {
+		t.Fatal("ACK accepted an expired lease")
+	}
+	if n, _ = nh.Get(ctx, uid); n == nil {
+		t.Fatal("note didn't come back after its lease ran out")
+	}
+
+	n, token, err = nh.Reserve(ctx, uid, time.Minute)
+	if err != nil || n == nil {
+		t.Fatal("RESERVE failed after the lease ran out")
+	}
+	wrong, _ := uuid.NewV4()
+	if acked, _ := nh.Ack(ctx, uid, wrong); acked {
+		t.Fatal("ACK accepted a wrong token")
+	}
+	acked, err := nh.Ack(ctx, uid, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !acked {
+		t.Fatal("ACK rejected a valid lease")
+	}
+	if n, _ = nh.Delete(ctx, uuid.Nil, uid); n != nil {
+		t.Fatal("ACK didn't delete the note")
+	}
+}
+
+func testNoteHandlerAttempts(t *testing.T, nh db.NoteHandler) {
+	ctx := context.Background()
+
+	uid, _ := uuid.NewV4()
+	note, err := nh.Create(ctx, &db.Note{ID: uid, Text: "guarded", Expiration: 10, PassHash: "$argon2id$hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if note.PassHash != "$argon2id$hash" {
+		t.Fatal("CREATE dropped the passphrase hash")
+	}
+
+	for i := 1; i < 3; i++ {
+		burnt, err := nh.FailAttempt(ctx, uid, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if burnt {
+			t.Fatal("FAIL burnt the note too early")
+		}
+		n, err := nh.Get(ctx, uid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == nil || n.FailedAttempts != i || n.PassHash != "$argon2id$hash" {
+			t.Fatal("FAIL didn't count the attempt")
+		}
+	}
+	burnt, err := nh.FailAttempt(ctx, uid, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !burnt {
+		t.Fatal("FAIL kept the note after the last attempt")
+	}
+	if n, _ := nh.Get(ctx, uid); n != nil {
+		t.Fatal("GET returned a burnt note")
+	}
+
+	missing, _ := uuid.NewV4()
+	if burnt, err = nh.FailAttempt(ctx, missing, 3); err != nil || burnt {
+		t.Fatal("FAIL burnt a note that doesn't exist")
+	}
+}
+
+func testNoteHandlerOwners(t *testing.T, nh db.NoteHandler) {
+	ctx := context.Background()
+
+	pupa, _ := uuid.NewV4()
+	lupa, _ := uuid.NewV4()
+	owned, _ := uuid.NewV4()
+	anonymous, _ := uuid.NewV4()
+	note, err := nh.Create(ctx, &db.Note{ID: owned, OwnerID: pupa, Text: "pupa's", Expiration: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if note.OwnerID != pupa {
+		t.Fatal("CREATE dropped the owner")
+	}
+	if _, err = nh.Create(ctx, &db.Note{ID: anonymous, Text: "nobody's", Expiration: 10}); err != nil {
+		t.Fatal(err)
+	}
+
+	notes, _, err := nh.List(ctx, db.ListFilter{Owner: pupa})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != 1 || notes[0].ID != owned || notes[0].OwnerID != pupa {
+		t.Fatal("LIST returned notes of another owner")
+	}
+	if notes, _, _ = nh.List(ctx, db.ListFilter{Owner: lupa}); len(notes) != 0 {
+		t.Fatal("LIST returned a note to a stranger")
+	}
+	notes, _, err = nh.List(ctx, db.ListFilter{AllOwners: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := 0
+	for _, n := range notes {
+		if n.ID == owned || n.ID == anonymous {
+			found++
+		}
+	}
+	if found != 2 {
+		t.Fatal("LIST of all owners missed a note")
+	}
+
+	if _, err = nh.Update(ctx, lupa, owned, &db.Note{Text: "lupa's now"}); err != db.ErrNotFound {
+		t.Fatal("UPDATE changed a note of another owner")
+	}
+	if _, err = nh.Update(ctx, uuid.Nil, owned, &db.Note{Text: "anyone's now"}); err != db.ErrNotFound {
+		t.Fatal("anonymous UPDATE changed an owned note")
+	}
+	if _, err = nh.Update(ctx, pupa, anonymous, &db.Note{Text: "pupa's now"}); err != db.ErrNotFound {
+		t.Fatal("UPDATE changed an anonymous note")
+	}
+	if n, err := nh.Update(ctx, pupa, owned, &db.Note{Text: "still pupa's"}); err != nil || n.Text != "still pupa's" {
+		t.Fatal("UPDATE rejected the owner")
+	}
+
+	if n, err := nh.Delete(ctx, lupa, owned); err != nil || n != nil {
+		t.Fatal("DELETE returned a note of another owner")
+	}
+	if n, _ := nh.Get(ctx, owned); n == nil {
+		t.Fatal("DELETE removed a note of another owner")
+	}
+	// reads go by the ID the note token gives, not by owner
+	if n, _ := nh.View(ctx, owned); n == nil || n.OwnerID != pupa {
+		t.Fatal("VIEW refused an owned note to its token")
+	}
+	if n, _ := nh.View(ctx, anonymous); n == nil {
+		t.Fatal("VIEW refused an anonymous note")
+	}
+	if n, token, _ := nh.Reserve(ctx, anonymous, time.Minute); n == nil {
+		t.Fatal("RESERVE refused an anonymous note")
+	} else if acked, _ := nh.Ack(ctx, anonymous, token); !acked {
+		t.Fatal("ACK refused an anonymous note")
+	}
+	if n, err := nh.Delete(ctx, pupa, owned); err != nil || n == nil || n.ID != owned {
+		t.Fatal("DELETE rejected the owner")
+	}
+
+	purged, _ := uuid.NewV4()
+	if _, err = nh.Create(ctx, &db.Note{ID: purged, OwnerID: lupa, Text: "lupa's", Expiration: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := nh.Purge(ctx, purged); err != nil || n == nil || n.ID != purged {
+		t.Fatal("PURGE didn't return the note")
+	}
+	if n, _ := nh.Get(ctx, purged); n != nil {
+		t.Fatal("PURGE kept the note")
+	}
+	if n, err := nh.Purge(ctx, purged); err != nil || n != nil {
+		t.Fatal("PURGE returned a note twice")
+	}
+}
+
+func testNoteHandlerSwap(t *testing.T, nh db.NoteHandler) {
+	ctx := context.Background()
+
+	pupa, _ := uuid.NewV4()
+	uid, _ := uuid.NewV4()
+	old := db.Note{Text: "b2xk", KeyID: "k1", DataKey: "a2V5"}
+	if _, err := nh.Create(ctx, &db.Note{ID: uid, OwnerID: pupa, Text: old.Text, KeyID: old.KeyID, DataKey: old.DataKey, Expiration: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := nh.Update(ctx, pupa, uid, &db.Note{Text: "ZWRpdGVk", KeyID: "k1", DataKey: "bmV3"}); err != nil {
+		t.Fatal(err)
+	}
+	if swapped, err := nh.Swap(ctx, uid, &old, &db.Note{Text: "b2xk", KeyID: "k2", DataKey: "a2V5"}); err != nil || swapped {
+		t.Fatal("SWAP overwrote an edited note")
+	}
+	if n, _ := nh.Get(ctx, uid); n == nil || n.Text != "ZWRpdGVk" || n.KeyID != "k1" {
+		t.Fatal("SWAP lost the edit")
+	}
+
+	edited := db.Note{Text: "ZWRpdGVk", KeyID: "k1", DataKey: "bmV3"}
+	if swapped, err := nh.Swap(ctx, uid, &edited, &db.Note{Text: "ZWRpdGVk", KeyID: "k2", DataKey: "cmV3cmFw"}); err != nil || !swapped {
+		t.Fatal("SWAP refused an unchanged note")
+	}
+	if n, _ := nh.Get(ctx, uid); n == nil || n.KeyID != "k2" || n.DataKey != "cmV3cmFw" || n.OwnerID != pupa {
+		t.Fatal("SWAP didn't write the note")
+	}
+
+	missing, _ := uuid.NewV4()
+	if swapped, err := nh.Swap(ctx, missing, &edited, &edited); err != nil || swapped {
+		t.Fatal("SWAP wrote a missing note")
+	}
+}
@@ -0,0 +1,149 @@
+package db
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const dataKeySize = 32
+
+var ErrUnknownKey = errors.New("unknown master key")
+
+// Keyring holds the master keys that wrap per-note data keys. New data keys
+// are always wrapped by the current key, the others are kept around so rows
+// written before a rotation can still be read until they are re-wrapped.
+type Keyring struct {
+	current string
+	keys    map[string][]byte
+}
+
+func NewKeyring(current string, keys map[string][]byte) (*Keyring, error) {
+	for id, key := range keys {
+		if len(key) != dataKeySize {
+			return nil, fmt.Errorf("master key %q must be %d bytes", id, dataKeySize)
+		}
+	}
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, current)
+	}
+	return &Keyring{current: current, keys: keys}, nil
+}
+
+// LoadKeyring reads master keys from c.MasterKeyFile, one "id:base64key" per
+// line, and from c.MasterKeys in the same format. The current key is
+// c.MasterKeyID or else the last key listed. It returns nil when no keys are
+// configured, which leaves encryption at rest off.
+func LoadKeyring(c Config) (*Keyring, error) {
+	var specs []string
+	if c.MasterKeyFile != "" {
+		f, err := os.Open(c.MasterKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			line := strings.TrimSpace(s.Text())
+			if line != "" && !strings.HasPrefix(line, "#") {
+				specs = append(specs, line)
+			}
+		}
+		if err = s.Err(); err != nil {
+			return nil, err
+		}
+	}
+	specs = append(specs, c.MasterKeys...)
+	if len(specs) == 0 {
+		return nil, nil
+	}
+
+	keys := make(map[string][]byte, len(specs))
+	current := c.MasterKeyID
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.New("master keys must look like id:base64key")
+		}
+		key, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("master key %q: %w", parts[0], err)
+		}
+		keys[parts[0]] = key
+		if c.MasterKeyID == "" {
+			current = parts[0]
+		}
+	}
+	return NewKeyring(current, keys)
+}
+
+func (k *Keyring) Current() string {
+	return k.current
+}
+
+// wrap seals dataKey with the current master key, binding it to the key ID.
+func (k *Keyring) wrap(dataKey []byte) (string, string, error) {
+	wrapped, err := sealGCM(k.keys[k.current], dataKey, []byte(k.current))
+	if err != nil {
+		return "", "", err
+	}
+	return k.current, base64.StdEncoding.EncodeToString(wrapped), nil
+}
+
+func (k *Keyring) unwrap(keyID, dataKey string) ([]byte, error) {
+	master, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, keyID)
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return openGCM(master, wrapped, []byte(keyID))
+}
+
+func newDataKey() ([]byte, error) {
+	key := make([]byte, dataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// sealGCM encrypts plaintext with AES-GCM and prefixes the random nonce.
+func sealGCM(key, plaintext, aad []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(plaintext)+gcm.Overhead())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func openGCM(key, data, aad []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], aad)
+}
@@ -0,0 +1,143 @@
+package db
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"github.com/gofrs/uuid"
+	"sort"
+	"time"
+)
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ListFilter selects a page of notes ordered by (created, id). Zero values
+// disable the corresponding filter, except for Owner: only notes of Owner
+// are listed unless AllOwners is set.
+type ListFilter struct {
+	Owner          uuid.UUID
+	AllOwners      bool
+	Cursor         string
+	Limit          int
+	CreatedBefore  time.Time
+	CreatedAfter   time.Time
+	ExpiringWithin time.Duration
+}
+
+func (f ListFilter) PageSize() int {
+	if f.Limit <= 0 {
+		return DefaultPageSize
+	}
+	if f.Limit > MaxPageSize {
+		return MaxPageSize
+	}
+	return f.Limit
+}
+
+type listCursor struct {
+	created time.Time
+	id      uuid.UUID
+}
+
+func encodeCursor(n *Note) string {
+	buf := make([]byte, 8+uuid.Size)
+	binary.BigEndian.PutUint64(buf, uint64(n.Created.UnixNano()))
+	copy(buf[8:], n.ID.Bytes())
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func (f ListFilter) cursor() (*listCursor, error) {
+	if f.Cursor == "" {
+		return nil, nil
+	}
+	buf, err := base64.RawURLEncoding.DecodeString(f.Cursor)
+	if err != nil || len(buf) != 8+uuid.Size {
+		return nil, ErrInvalidCursor
+	}
+	id, err := uuid.FromBytes(buf[8:])
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &listCursor{
+		created: time.Unix(0, int64(binary.BigEndian.Uint64(buf))),
+		id:      id,
+	}, nil
+}
+
+func (c *listCursor) before(n *Note) bool {
+	if c == nil {
+		return true
+	}
+	if !n.Created.Equal(c.created) {
+		return c.created.Before(n.Created)
+	}
+	return bytes.Compare(c.id.Bytes(), n.ID.Bytes()) < 0
+}
+
+// match drops expired and used up notes and applies the owner and time
+// filters, the cursor is handled by the caller.
+func (f ListFilter) match(n *Note, now time.Time) bool {
+	if !n.visible(now) {
+		return false
+	}
+	if !f.AllOwners && !n.ownedBy(f.Owner) {
+		return false
+	}
+	if !f.CreatedBefore.IsZero() && !n.Created.Before(f.CreatedBefore) {
+		return false
+	}
+	if !f.CreatedAfter.IsZero() && !n.Created.After(f.CreatedAfter) {
+		return false
+	}
+	if f.ExpiringWithin > 0 && n.ExpiresAt().After(now.Add(f.ExpiringWithin)) {
+		return false
+	}
+	return true
+}
+
+// page trims notes fetched with one extra row to the page size and returns
+// the cursor of the next page, if there is one.
+func (f ListFilter) page(notes []*Note) ([]*Note, string) {
+	size := f.PageSize()
+	if len(notes) <= size {
+		return notes, ""
+	}
+	notes = notes[:size]
+	return notes, encodeCursor(notes[len(notes)-1])
+}
+
+// paginate pages through an unordered set of notes held by the caller.
+func (f ListFilter) paginate(notes []*Note) ([]*Note, string, error) {
+	c, err := f.cursor()
+	if err != nil {
+		return nil, "", err
+	}
+
+	sort.Slice(notes, func(i, j int) bool {
+		if !notes[i].Created.Equal(notes[j].Created) {
+			return notes[i].Created.Before(notes[j].Created)
+		}
+		return bytes.Compare(notes[i].ID.Bytes(), notes[j].ID.Bytes()) < 0
+	})
+
+	now := time.Now()
+	size := f.PageSize()
+	var page []*Note
+	for _, n := range notes {
+		if !c.before(n) || !f.match(n, now) {
+			continue
+		}
+		page = append(page, n)
+		if len(page) > size {
+			break
+		}
+	}
+	page, next := f.page(page)
+	return page, next, nil
+}
@@ -0,0 +1,279 @@
+package db
+
+import (
+	"container/heap"
+	"context"
+	"github.com/gofrs/uuid"
+	"sync"
+	"time"
+)
+
+type expiryItem struct {
+	id       uuid.UUID
+	deadline time.Time
+	index    int
+}
+
+type expiryHeap []*expiryItem
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].deadline.Before(h[j].deadline) }
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *expiryHeap) Push(x interface{}) {
+	item := x.(*expiryItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	item.index = -1
+	return item
+}
+
+type memoryNote struct {
+	note       *Note
+	expiry     *expiryItem
+	leaseToken uuid.UUID
+	leaseUntil time.Time
+}
+
+func (mn *memoryNote) visible(now time.Time) bool {
+	return mn.note.visible(now) && !mn.leaseUntil.After(now)
+}
+
+// MemoryDB keeps notes in process memory. Every note is dropped by a timer
+// at its exact deadline, ClearExpired only has to catch up on timers that
+// fired late and reads skip notes whose timer hasn't fired yet.
+type MemoryDB struct {
+	mu     sync.Mutex
+	notes  map[uuid.UUID]*memoryNote
+	expiry expiryHeap
+	timer  *time.Timer
+	closed bool
+}
+
+func NewMemoryDB() *MemoryDB {
+	return &MemoryDB{notes: make(map[uuid.UUID]*memoryNote)}
+}
+
+func (m *MemoryDB) Create(ctx context.Context, n *Note) (*Note, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if old, ex := m.notes[n.ID]; ex {
+		heap.Remove(&m.expiry, old.expiry.index)
+	}
+
+	note := newNote(n)
+	item := &expiryItem{id: note.ID, deadline: note.ExpiresAt()}
+	heap.Push(&m.expiry, item)
+	m.notes[note.ID] = &memoryNote{note: note, expiry: item}
+	m.schedule()
+
+	return note.clone(), nil
+}
+
+func (m *MemoryDB) Get(ctx context.Context, uid uuid.UUID) (*Note, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mn, ex := m.notes[uid]
+	if !ex || !mn.visible(time.Now()) {
+		return nil, nil
+	}
+	return mn.note.clone(), nil
+}
+
+func (m *MemoryDB) View(ctx context.Context, uid uuid.UUID) (*Note, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mn, ex := m.notes[uid]
+	if !ex || !mn.visible(time.Now()) {
+		return nil, nil
+	}
+	mn.note.countView()
+	if mn.note.Exhausted() {
+		m.remove(mn)
+	}
+	return mn.note.clone(), nil
+}
+
+func (m *MemoryDB) FailAttempt(ctx context.Context, uid uuid.UUID, maxAttempts int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mn, ex := m.notes[uid]
+	if !ex || !mn.visible(time.Now()) {
+		return false, nil
+	}
+	mn.note.FailedAttempts++
+	if mn.note.FailedAttempts < maxAttempts {
+		return false, nil
+	}
+	m.remove(mn)
+	return true, nil
+}
+
+func (m *MemoryDB) List(ctx context.Context, f ListFilter) ([]*Note, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	notes := make([]*Note, 0, len(m.notes))
+	for _, mn := range m.notes {
+		if mn.visible(now) {
+			notes = append(notes, mn.note.clone())
+		}
+	}
+	return f.paginate(notes)
+}
+
+func (m *MemoryDB) Update(ctx context.Context, owner uuid.UUID, uid uuid.UUID, content *Note) (*Note, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mn, ex := m.notes[uid]
+	if !ex || !mn.visible(time.Now()) || !mn.note.ownedBy(owner) {
+		return nil, ErrNotFound
+	}
+	mn.note.setContent(content)
+	return mn.note.clone(), nil
+}
+
+func (m *MemoryDB) Swap(ctx context.Context, uid uuid.UUID, old *Note, content *Note) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mn, ex := m.notes[uid]
+	if !ex || !mn.visible(time.Now()) || !mn.note.sameContent(old) {
+		return false, nil
+	}
+	mn.note.setContent(content)
+	return true, nil
+}
+
+func (m *MemoryDB) Delete(ctx context.Context, owner uuid.UUID, uid uuid.UUID) (*Note, error) {
+	return m.delete(uid, func(n *Note) bool { return n.ownedBy(owner) })
+}
+
+func (m *MemoryDB) Purge(ctx context.Context, uid uuid.UUID) (*Note, error) {
+	return m.delete(uid, func(n *Note) bool { return true })
+}
+
+func (m *MemoryDB) delete(uid uuid.UUID, allowed func(n *Note) bool) (*Note, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mn, ex := m.notes[uid]
+	if !ex || !allowed(mn.note) {
+		return nil, nil
+	}
+	m.remove(mn)
+
+	if !mn.note.visible(time.Now()) {
+		return nil, nil
+	}
+	return mn.note.clone(), nil
+}
+
+func (m *MemoryDB) Reserve(ctx context.Context, uid uuid.UUID, lease time.Duration) (*Note, uuid.UUID, error) {
+	token, err := uuid.NewV4()
+	if err != nil {
+		return nil, uuid.Nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	mn, ex := m.notes[uid]
+	if !ex || !mn.visible(now) {
+		return nil, uuid.Nil, nil
+	}
+	mn.leaseToken = token
+	mn.leaseUntil = now.Add(lease)
+	return mn.note.clone(), token, nil
+}
+
+func (m *MemoryDB) Ack(ctx context.Context, uid uuid.UUID, token uuid.UUID) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mn, ex := m.notes[uid]
+	if !ex || mn.leaseToken != token || !mn.leaseUntil.After(time.Now()) {
+		return false, nil
+	}
+	m.remove(mn)
+	return true, nil
+}
+
+func (m *MemoryDB) ClearExpired(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.dropExpired(time.Now())
+	m.schedule()
+	return nil
+}
+
+// Len reports how many notes are held, including expired ones whose timer
+// has not fired yet.
+func (m *MemoryDB) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.notes)
+}
+
+func (m *MemoryDB) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.closed = true
+	if m.timer != nil {
+		m.timer.Stop()
+	}
+}
+
+// remove drops mn from the store. Must be called with m.mu held.
+func (m *MemoryDB) remove(mn *memoryNote) {
+	heap.Remove(&m.expiry, mn.expiry.index)
+	delete(m.notes, mn.note.ID)
+	m.schedule()
+}
+
+func (m *MemoryDB) dropExpired(now time.Time) {
+	for m.expiry.Len() > 0 && !m.expiry[0].deadline.After(now) {
+		item := heap.Pop(&m.expiry).(*expiryItem)
+		delete(m.notes, item.id)
+	}
+}
+
+// schedule arms the timer for the earliest deadline. Must be called with
+// m.mu held.
+func (m *MemoryDB) schedule() {
+	if m.timer != nil {
+		m.timer.Stop()
+	}
+	if m.closed || m.expiry.Len() == 0 {
+		return
+	}
+
+	m.timer = time.AfterFunc(time.Until(m.expiry[0].deadline), func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		m.dropExpired(time.Now())
+		m.schedule()
+	})
+}
@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+	"github.com/gofrs/uuid"
+	"sync"
+	"time"
+)
+
+type MemoryShareDB struct {
+	mu    sync.Mutex
+	links map[uuid.UUID]*ShareLink
+}
+
+func NewMemoryShareDB() *MemoryShareDB {
+	return &MemoryShareDB{links: make(map[uuid.UUID]*ShareLink)}
+}
+
+func (m *MemoryShareDB) CreateShare(ctx context.Context, l *ShareLink) (*ShareLink, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	link := newShareLink(l)
+	for id, old := range m.links {
+		if !old.ExpiresAt.After(link.Created) {
+			delete(m.links, id)
+		}
+	}
+	m.links[link.ID] = link
+	return link.clone(), nil
+}
+
+func (m *MemoryShareDB) GetShare(ctx context.Context, id uuid.UUID) (*ShareLink, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l, ex := m.links[id]
+	if !ex || !l.Usable(time.Now()) {
+		return nil, nil
+	}
+	return l.clone(), nil
+}
+
+func (m *MemoryShareDB) ListShares(ctx context.Context, noteID uuid.UUID) ([]*ShareLink, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var links []*ShareLink
+	for _, l := range m.links {
+		if l.NoteID == noteID && l.Usable(now) {
+			links = append(links, l.clone())
+		}
+	}
+	sortShares(links)
+	return links, nil
+}
+
+func (m *MemoryShareDB) UseShare(ctx context.Context, id uuid.UUID) (*ShareLink, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l, ex := m.links[id]
+	if !ex || !l.Usable(time.Now()) {
+		return nil, nil
+	}
+	l.countView()
+	return l.clone(), nil
+}
+
+func (m *MemoryShareDB) RevokeShare(ctx context.Context, noteID, id uuid.UUID) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l, ex := m.links[id]
+	if !ex || l.NoteID != noteID {
+		return false, nil
+	}
+	delete(m.links, id)
+	return true, nil
+}
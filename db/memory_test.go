@@ -0,0 +1,81 @@
+package db_test
+
+import (
+	"context"
+	"github.com/gofrs/uuid"
+	"github.com/pimka/go-onenote/db"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryDB(t *testing.T) {
+	mdb := db.NewMemoryDB()
+	defer mdb.Close()
+
+	testNoteHandler(t, mdb)
+	testNoteHandlerPages(t, mdb)
+	testNoteHandlerViews(t, mdb)
+	testNoteHandlerAttempts(t, mdb)
+	testNoteHandlerOwners(t, mdb)
+	testNoteHandlerSwap(t, mdb)
+	testNoteHandlerLeases(t, mdb)
+	testUserHandler(t, db.NewMemoryUserDB())
+	testTokenHandler(t, db.NewMemoryTokenDB(), db.NewMemoryUserDB())
+	testShareHandler(t, db.NewMemoryShareDB(), mdb)
+}
+
+func TestMemoryDB_Concurrent(t *testing.T) {
+	mdb := db.NewMemoryDB()
+	defer mdb.Close()
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			uid, _ := uuid.NewV4()
+			if _, err := mdb.Create(ctx, &db.Note{ID: uid, Text: "test"}); err != nil {
+				t.Error(err)
+			}
+			mdb.Get(ctx, uid)
+			mdb.Delete(ctx, uuid.Nil, uid)
+		}()
+	}
+	wg.Wait()
+
+	notes, _, err := mdb.List(ctx, db.ListFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != 0 {
+		t.Error("notes survived Delete")
+	}
+}
+
+func TestMemoryDB_Expiry(t *testing.T) {
+	mdb := db.NewMemoryDB()
+	defer mdb.Close()
+	ctx := context.Background()
+
+	expired, _ := uuid.NewV4()
+	kept, _ := uuid.NewV4()
+	if _, err := mdb.Create(ctx, &db.Note{ID: expired, Text: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mdb.Create(ctx, &db.Note{ID: kept, Text: "test", Expiration: 10}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Neither Delete nor ClearExpired is called: only the timer can drop it.
+	for bound := time.Now().Add(time.Second); mdb.Len() != 1; {
+		if time.Now().After(bound) {
+			t.Fatalf("expired note still held after 1s, %d notes", mdb.Len())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if _, err := mdb.Get(ctx, kept); err != nil {
+		t.Errorf("unexpired note dropped: %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package db
+
+import (
+	"context"
+	"github.com/gofrs/uuid"
+	"sync"
+	"time"
+)
+
+type MemoryTokenDB struct {
+	mu     sync.Mutex
+	tokens map[uuid.UUID]*AccessToken
+	byHash map[string]uuid.UUID
+}
+
+func NewMemoryTokenDB() *MemoryTokenDB {
+	return &MemoryTokenDB{
+		tokens: make(map[uuid.UUID]*AccessToken),
+		byHash: make(map[string]uuid.UUID),
+	}
+}
+
+func (m *MemoryTokenDB) CreateToken(ctx context.Context, t *AccessToken) (*AccessToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	token := newAccessToken(t)
+	m.tokens[token.ID] = token
+	m.byHash[token.Hash] = token.ID
+	return token.clone(), nil
+}
+
+func (m *MemoryTokenDB) GetTokenByHash(ctx context.Context, hash string) (*AccessToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id, ex := m.byHash[hash]
+	if !ex {
+		return nil, nil
+	}
+	return m.tokens[id].clone(), nil
+}
+
+func (m *MemoryTokenDB) ListTokens(ctx context.Context, userID uuid.UUID) ([]*AccessToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var tokens []*AccessToken
+	for _, t := range m.tokens {
+		if t.UserID == userID {
+			tokens = append(tokens, t.clone())
+		}
+	}
+	sortTokens(tokens)
+	return tokens, nil
+}
+
+func (m *MemoryTokenDB) RevokeToken(ctx context.Context, userID, id uuid.UUID) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ex := m.tokens[id]
+	if !ex || t.UserID != userID {
+		return false, nil
+	}
+	delete(m.tokens, id)
+	delete(m.byHash, t.Hash)
+	return true, nil
+}
+
+func (m *MemoryTokenDB) TouchToken(ctx context.Context, id uuid.UUID, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if t, ex := m.tokens[id]; ex {
+		t.LastUsed = &at
+	}
+	return nil
+}
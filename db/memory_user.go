@@ -0,0 +1,68 @@
+package db
+
+import (
+	"context"
+	"github.com/gofrs/uuid"
+	"sync"
+)
+
+type MemoryUserDB struct {
+	mu     sync.Mutex
+	users  map[uuid.UUID]*User
+	byName map[string]uuid.UUID
+}
+
+func NewMemoryUserDB() *MemoryUserDB {
+	return &MemoryUserDB{
+		users:  make(map[uuid.UUID]*User),
+		byName: make(map[string]uuid.UUID),
+	}
+}
+
+func (m *MemoryUserDB) CreateUser(ctx context.Context, u *User) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ex := m.byName[u.Username]; ex {
+		return nil, ErrUserExists
+	}
+	user := newUser(u)
+	m.users[user.ID] = user
+	m.byName[user.Username] = user.ID
+	c := *user
+	return &c, nil
+}
+
+func (m *MemoryUserDB) GetUser(ctx context.Context, uid uuid.UUID) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ex := m.users[uid]
+	if !ex {
+		return nil, nil
+	}
+	c := *user
+	return &c, nil
+}
+
+func (m *MemoryUserDB) GetUserByName(ctx context.Context, username string) (*User, error) {
+	m.mu.Lock()
+	uid, ex := m.byName[username]
+	m.mu.Unlock()
+	if !ex {
+		return nil, nil
+	}
+	return m.GetUser(ctx, uid)
+}
+
+func (m *MemoryUserDB) SetRole(ctx context.Context, uid uuid.UUID, role string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ex := m.users[uid]
+	if !ex {
+		return false, nil
+	}
+	user.Role = role
+	return true, nil
+}
@@ -0,0 +1,97 @@
+package db
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/postgres"
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+)
+
+//go:embed migrations/postgres/*.sql migrations/sqlite/*.sql
+var migrations embed.FS
+
+var migrationDirs = map[string]string{
+	DriverPostgres: "migrations/postgres",
+	DriverSQLite:   "migrations/sqlite",
+}
+
+type Migrator struct {
+	m *migrate.Migrate
+}
+
+func NewMigrator(c Config) (*Migrator, error) {
+	dir, ok := migrationDirs[c.Driver]
+	if !ok {
+		return nil, fmt.Errorf("storage driver %q has no migrations", c.Driver)
+	}
+	url := c.URL
+	if c.Driver == DriverSQLite {
+		url = "sqlite://" + c.SQLitePath
+	}
+
+	src, err := iofs.New(migrations, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := migrate.NewWithSourceInstance("iofs", src, url)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{m: m}, nil
+}
+
+func (mg *Migrator) Up() error {
+	if err := mg.m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return err
+	}
+	return nil
+}
+
+func (mg *Migrator) Down() error {
+	if err := mg.m.Steps(-1); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return err
+	}
+	return nil
+}
+
+func (mg *Migrator) Status() (string, error) {
+	version, dirty, err := mg.m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		return "no migrations applied", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if dirty {
+		return fmt.Sprintf("version %d (dirty)", version), nil
+	}
+	return fmt.Sprintf("version %d", version), nil
+}
+
+func (mg *Migrator) Close() error {
+	srcErr, dbErr := mg.m.Close()
+	if srcErr != nil {
+		return srcErr
+	}
+	return dbErr
+}
+
+// Migrate applies all pending migrations. Drivers without a schema are
+// left alone.
+func Migrate(c Config) error {
+	if _, ok := migrationDirs[c.Driver]; !ok {
+		return nil
+	}
+
+	mg, err := NewMigrator(c)
+	if err != nil {
+		return err
+	}
+	defer mg.Close()
+
+	return mg.Up()
+}
@@ -0,0 +1,3 @@
+ALTER TABLE notes
+    DROP COLUMN IF EXISTS views_remaining,
+    DROP COLUMN IF EXISTS max_views;
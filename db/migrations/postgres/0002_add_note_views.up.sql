@@ -0,0 +1,3 @@
+ALTER TABLE notes
+    ADD COLUMN IF NOT EXISTS max_views       INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS views_remaining INTEGER;
@@ -0,0 +1,3 @@
+ALTER TABLE notes
+    DROP COLUMN IF EXISTS lease_until,
+    DROP COLUMN IF EXISTS lease_token;
@@ -0,0 +1,3 @@
+ALTER TABLE notes
+    ADD COLUMN IF NOT EXISTS lease_token UUID,
+    ADD COLUMN IF NOT EXISTS lease_until TIMESTAMPTZ;
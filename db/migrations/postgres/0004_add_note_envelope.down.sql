@@ -0,0 +1,3 @@
+ALTER TABLE notes
+    DROP COLUMN IF EXISTS alg,
+    DROP COLUMN IF EXISTS nonce;
@@ -0,0 +1,3 @@
+ALTER TABLE notes
+    ADD COLUMN IF NOT EXISTS nonce TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS alg   TEXT NOT NULL DEFAULT '';
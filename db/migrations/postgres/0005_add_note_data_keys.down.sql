@@ -0,0 +1,3 @@
+ALTER TABLE notes
+    DROP COLUMN IF EXISTS data_key,
+    DROP COLUMN IF EXISTS key_id;
@@ -0,0 +1,3 @@
+ALTER TABLE notes
+    ADD COLUMN IF NOT EXISTS key_id   TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS data_key TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+ALTER TABLE notes
+    DROP COLUMN IF EXISTS failed_attempts,
+    DROP COLUMN IF EXISTS pass_hash;
@@ -0,0 +1,3 @@
+ALTER TABLE notes
+    ADD COLUMN IF NOT EXISTS pass_hash       TEXT    NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS failed_attempts INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS users (
+    id        UUID PRIMARY KEY,
+    username  TEXT        NOT NULL UNIQUE,
+    pass_hash TEXT        NOT NULL,
+    created   TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS access_tokens (
+    id         UUID PRIMARY KEY,
+    user_id    UUID        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name       TEXT        NOT NULL,
+    hash       TEXT        NOT NULL UNIQUE,
+    scopes     TEXT        NOT NULL,
+    created    TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ,
+    last_used  TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS access_tokens_user_id_idx ON access_tokens (user_id);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS notes_owner_id_created_idx;
+ALTER TABLE notes DROP COLUMN IF EXISTS owner_id;
@@ -0,0 +1,4 @@
+ALTER TABLE notes
+    ADD COLUMN IF NOT EXISTS owner_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000000';
+
+CREATE INDEX IF NOT EXISTS notes_owner_id_created_idx ON notes (owner_id, created);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS share_links (
+    id              UUID PRIMARY KEY,
+    note_id         UUID        NOT NULL REFERENCES notes (id) ON DELETE CASCADE,
+    permission      TEXT        NOT NULL,
+    created         TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at      TIMESTAMPTZ NOT NULL,
+    max_views       INTEGER     NOT NULL DEFAULT 0,
+    views_remaining INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS share_links_note_id_idx ON share_links (note_id);
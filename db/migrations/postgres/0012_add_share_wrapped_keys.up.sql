@@ -0,0 +1 @@
+ALTER TABLE share_links ADD COLUMN IF NOT EXISTS wrapped_key TEXT NOT NULL DEFAULT '';
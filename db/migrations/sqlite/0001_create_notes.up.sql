@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS notes (
+    id         TEXT PRIMARY KEY,
+    text       TEXT    NOT NULL,
+    created    INTEGER NOT NULL,
+    expiration INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS notes_created_idx ON notes (created);
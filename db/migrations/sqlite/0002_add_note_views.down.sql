@@ -0,0 +1,2 @@
+ALTER TABLE notes DROP COLUMN views_remaining;
+ALTER TABLE notes DROP COLUMN max_views;
@@ -0,0 +1,2 @@
+ALTER TABLE notes ADD COLUMN max_views INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE notes ADD COLUMN views_remaining INTEGER;
@@ -0,0 +1,2 @@
+ALTER TABLE notes DROP COLUMN lease_until;
+ALTER TABLE notes DROP COLUMN lease_token;
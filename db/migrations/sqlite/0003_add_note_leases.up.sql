@@ -0,0 +1,2 @@
+ALTER TABLE notes ADD COLUMN lease_token TEXT;
+ALTER TABLE notes ADD COLUMN lease_until INTEGER;
@@ -0,0 +1,2 @@
+ALTER TABLE notes DROP COLUMN alg;
+ALTER TABLE notes DROP COLUMN nonce;
@@ -0,0 +1,2 @@
+ALTER TABLE notes ADD COLUMN nonce TEXT NOT NULL DEFAULT '';
+ALTER TABLE notes ADD COLUMN alg TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+ALTER TABLE notes DROP COLUMN data_key;
+ALTER TABLE notes DROP COLUMN key_id;
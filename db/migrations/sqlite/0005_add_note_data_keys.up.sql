@@ -0,0 +1,2 @@
+ALTER TABLE notes ADD COLUMN key_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE notes ADD COLUMN data_key TEXT NOT NULL DEFAULT '';
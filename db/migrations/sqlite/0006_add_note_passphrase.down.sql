@@ -0,0 +1,2 @@
+ALTER TABLE notes DROP COLUMN failed_attempts;
+ALTER TABLE notes DROP COLUMN pass_hash;
@@ -0,0 +1,2 @@
+ALTER TABLE notes ADD COLUMN pass_hash TEXT NOT NULL DEFAULT '';
+ALTER TABLE notes ADD COLUMN failed_attempts INTEGER NOT NULL DEFAULT 0;
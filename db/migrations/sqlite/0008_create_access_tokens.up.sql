@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS access_tokens (
+    id         TEXT PRIMARY KEY,
+    user_id    TEXT    NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name       TEXT    NOT NULL,
+    hash       TEXT    NOT NULL UNIQUE,
+    scopes     TEXT    NOT NULL,
+    created    INTEGER NOT NULL,
+    expires_at INTEGER,
+    last_used  INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS access_tokens_user_id_idx ON access_tokens (user_id);
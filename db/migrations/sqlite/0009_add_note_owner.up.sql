@@ -0,0 +1,3 @@
+ALTER TABLE notes ADD COLUMN owner_id TEXT NOT NULL DEFAULT '00000000-0000-0000-0000-000000000000';
+
+CREATE INDEX IF NOT EXISTS notes_owner_id_created_idx ON notes (owner_id, created);
@@ -4,43 +4,109 @@ import (
 	"context"
 	"fmt"
 	"github.com/gofrs/uuid"
-	"github.com/jackc/pgx/v4"
 	"time"
 )
 
+type mockLease struct {
+	token uuid.UUID
+	until time.Time
+}
+
 type MockDB struct {
-	Notes []*Note
+	Notes  []*Note
+	leases map[uuid.UUID]mockLease
+}
+
+func (m *MockDB) visible(n *Note, now time.Time) bool {
+	return n.visible(now) && !m.leases[n.ID].until.After(now)
 }
 
-func (m *MockDB) Create(ctx context.Context, uid uuid.UUID, text string, exp_time int) (*Note, error) {
-	note := m.push(text, exp_time)
+func (m *MockDB) Create(ctx context.Context, n *Note) (*Note, error) {
+	note := newNote(n)
+	m.Notes = append(m.Notes, note)
 	return note, nil
 }
 
 func (m *MockDB) Get(ctx context.Context, uid uuid.UUID) (*Note, error) {
 	for _, n := range m.Notes {
-		if n.ID == uid {
+		if n.ID == uid && m.visible(n, time.Now()) {
 			return n, nil
 		}
 	}
-	return nil, pgx.ErrNoRows
+	return nil, nil
 }
 
-func (m *MockDB) List(ctx context.Context) ([]*Note, error) {
-	return m.Notes, nil
+func (m *MockDB) View(ctx context.Context, uid uuid.UUID) (*Note, error) {
+	note, _ := m.Get(ctx, uid)
+	if note == nil {
+		return nil, nil
+	}
+	note.countView()
+	if note.Exhausted() {
+		m.remove(uid)
+	}
+	return note, nil
 }
 
-func (m *MockDB) Update(ctx context.Context, uid uuid.UUID, newText string) (*Note, error) {
+func (m *MockDB) FailAttempt(ctx context.Context, uid uuid.UUID, maxAttempts int) (bool, error) {
+	note, _ := m.Get(ctx, uid)
+	if note == nil {
+		return false, nil
+	}
+	note.FailedAttempts++
+	if note.FailedAttempts < maxAttempts {
+		return false, nil
+	}
+	m.remove(uid)
+	return true, nil
+}
+
+func (m *MockDB) List(ctx context.Context, f ListFilter) ([]*Note, string, error) {
+	var notes []*Note
 	for _, n := range m.Notes {
-		if n.ID == uid {
-			n.Text = newText
+		if m.visible(n, time.Now()) {
+			notes = append(notes, n)
+		}
+	}
+	return f.paginate(notes)
+}
+
+func (m *MockDB) Update(ctx context.Context, owner uuid.UUID, uid uuid.UUID, content *Note) (*Note, error) {
+	for _, n := range m.Notes {
+		if n.ID == uid && n.ownedBy(owner) && m.visible(n, time.Now()) {
+			n.setContent(content)
 			return n, nil
 		}
 	}
-	return nil, pgx.ErrNoRows
+	return nil, ErrNotFound
 }
 
-func (m *MockDB) Delete(ctx context.Context, uid uuid.UUID) (*Note, error) {
+func (m *MockDB) Swap(ctx context.Context, uid uuid.UUID, old *Note, content *Note) (bool, error) {
+	for _, n := range m.Notes {
+		if n.ID == uid && n.sameContent(old) && m.visible(n, time.Now()) {
+			n.setContent(content)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *MockDB) Delete(ctx context.Context, owner uuid.UUID, uid uuid.UUID) (*Note, error) {
+	for _, n := range m.Notes {
+		if n.ID == uid && !n.ownedBy(owner) {
+			return nil, nil
+		}
+	}
+	return m.remove(uid), nil
+}
+
+func (m *MockDB) Purge(ctx context.Context, uid uuid.UUID) (*Note, error) {
+	return m.remove(uid), nil
+}
+
+// remove drops the note and its lease, it returns the note if it was still
+// visible.
+func (m *MockDB) remove(uid uuid.UUID) *Note {
 	delIdx := -1
 	var note *Note
 	for idx, n := range m.Notes {
@@ -50,20 +116,44 @@ func (m *MockDB) Delete(ctx context.Context, uid uuid.UUID) (*Note, error) {
 		}
 	}
 	if delIdx == -1 {
-		return nil, pgx.ErrNoRows
+		return nil
 	}
 
-	notes := m.Notes
-	notes[len(notes)-1], notes[delIdx] = notes[delIdx], notes[len(notes)-1]
-	m.Notes = notes
-	return note, nil
+	m.Notes = append(m.Notes[:delIdx:delIdx], m.Notes[delIdx+1:]...)
+	delete(m.leases, uid)
+	if !note.visible(time.Now()) {
+		return nil
+	}
+	return note
+}
+
+func (m *MockDB) Reserve(ctx context.Context, uid uuid.UUID, lease time.Duration) (*Note, uuid.UUID, error) {
+	note, _ := m.Get(ctx, uid)
+	if note == nil {
+		return nil, uuid.Nil, nil
+	}
+	if m.leases == nil {
+		m.leases = make(map[uuid.UUID]mockLease)
+	}
+	token, _ := uuid.NewV4()
+	m.leases[uid] = mockLease{token: token, until: time.Now().Add(lease)}
+	return note, token, nil
+}
+
+func (m *MockDB) Ack(ctx context.Context, uid uuid.UUID, token uuid.UUID) (bool, error) {
+	l, ex := m.leases[uid]
+	if !ex || l.token != token || !l.until.After(time.Now()) {
+		return false, nil
+	}
+	m.remove(uid)
+	return true, nil
 }
 
 func (m *MockDB) ClearExpired(ctx context.Context) error {
 	var newNotes []*Note
 	now := time.Now()
 	for _, n := range m.Notes {
-		if now.After(n.Created.Add(time.Minute * time.Duration(n.Expiration))) {
+		if !now.After(n.ExpiresAt()) {
 			newNotes = append(newNotes, n)
 		}
 	}
@@ -74,14 +164,14 @@ func (m *MockDB) ClearExpired(ctx context.Context) error {
 func NewMockDB() *MockDB {
 	mdb := &MockDB{}
 	for i := 0; i < 20; i++ {
-		mdb.push(fmt.Sprintf("pupa-test-%d", i), i)
+		uid, _ := uuid.NewV4()
+		mdb.push(uid, fmt.Sprintf("pupa-test-%d", i), i+1)
 	}
 	return mdb
 }
 
-func (m *MockDB) push(text string, expiration int) *Note {
+func (m *MockDB) push(uid uuid.UUID, text string, expiration int) *Note {
 	created := time.Now()
-	uid, _ := uuid.NewV4()
 	note := &Note{
 		ID:         uid,
 		Text:       text,
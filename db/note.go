@@ -2,41 +2,193 @@ package db
 
 import (
 	"context"
+	"errors"
 	sq "github.com/Masterminds/squirrel"
 	"github.com/gofrs/uuid"
 	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
 	"time"
 )
 
 type Note struct {
-	ID         uuid.UUID
+	ID uuid.UUID
+	// OwnerID is the user who created the note, uuid.Nil for anonymous
+	// notes.
+	OwnerID    uuid.UUID `json:"owner_id"`
 	Text       string
 	Created    time.Time
 	Expiration int
+	// MaxViews limits how many times the note can be viewed, 0 means no
+	// limit and leaves ViewsRemaining nil.
+	MaxViews       int  `json:"max_views,omitempty"`
+	ViewsRemaining *int `json:"views_remaining,omitempty"`
+	// End-to-end encrypted notes keep the ciphertext in Text, Nonce and
+	// Algorithm are empty for plaintext notes.
+	Nonce     string `json:"nonce,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	// Notes encrypted at rest keep the ID of the master key and the data key
+	// wrapped by it. CryptHandler fills them in and strips them on the way
+	// out, so they never reach API responses.
+	KeyID   string `json:"key_id,omitempty"`
+	DataKey string `json:"data_key,omitempty"`
+	// PassHash is the Argon2id hash of the passphrase protecting the note,
+	// FailedAttempts counts wrong passphrases given so far.
+	PassHash       string `json:"pass_hash,omitempty"`
+	FailedAttempts int    `json:"failed_attempts,omitempty"`
 }
 
+var ErrNotFound = errors.New("note not found")
+
+func (n *Note) ExpiresAt() time.Time {
+	return n.Created.Add(time.Minute * time.Duration(n.Expiration))
+}
+
+// newNote copies the fields a caller may set on Create and fills in the
+// ones owned by the store.
+func newNote(n *Note) *Note {
+	note := &Note{
+		ID:         n.ID,
+		OwnerID:    n.OwnerID,
+		Text:       n.Text,
+		Created:    time.Now(),
+		Expiration: n.Expiration,
+		Nonce:      n.Nonce,
+		Algorithm:  n.Algorithm,
+		KeyID:      n.KeyID,
+		DataKey:    n.DataKey,
+		PassHash:   n.PassHash,
+	}
+	if n.MaxViews > 0 {
+		views := n.MaxViews
+		note.MaxViews = views
+		note.ViewsRemaining = &views
+	}
+	return note
+}
+
+// ownedBy reports whether owner may list, update or delete n. Anonymous
+// notes belong to the anonymous owner uuid.Nil.
+func (n *Note) ownedBy(owner uuid.UUID) bool {
+	return n.OwnerID == owner
+}
+
+func (n *Note) setContent(content *Note) {
+	n.Text = content.Text
+	n.Nonce = content.Nonce
+	n.Algorithm = content.Algorithm
+	n.KeyID = content.KeyID
+	n.DataKey = content.DataKey
+}
+
+// sameContent reports whether n still holds the stored text and data key of
+// old.
+func (n *Note) sameContent(old *Note) bool {
+	return n.Text == old.Text && n.KeyID == old.KeyID && n.DataKey == old.DataKey
+}
+
+// clone copies n, including the view counter, for backends that hand out
+// notes they keep in memory.
+func (n *Note) clone() *Note {
+	c := *n
+	if n.ViewsRemaining != nil {
+		views := *n.ViewsRemaining
+		c.ViewsRemaining = &views
+	}
+	return &c
+}
+
+// countView takes one view off a view limited note.
+func (n *Note) countView() {
+	if n.ViewsRemaining != nil {
+		views := *n.ViewsRemaining - 1
+		n.ViewsRemaining = &views
+	}
+}
+
+func (n *Note) Exhausted() bool {
+	return n.ViewsRemaining != nil && *n.ViewsRemaining <= 0
+}
+
+// Expired reports whether n is past its deadline. Backends never return
+// expired notes, whether or not ClearExpired has run since.
+func (n *Note) Expired(now time.Time) bool {
+	return !n.ExpiresAt().After(now)
+}
+
+func (n *Note) visible(now time.Time) bool {
+	return !n.Expired(now) && !n.Exhausted()
+}
+
+// NoteHandler stores notes. The owner of a note decides who may list,
+// update and delete it. Reads by ID, that is Get, View, Reserve, Ack and
+// FailAttempt, take no owner on purpose: the ID is derived from the note
+// token, and holding the token is what lets someone read the note. That is
+// how a one-time note reaches its reader, who is rarely its owner and often
+// anonymous, so these reads work the same whoever asks.
 type NoteHandler interface {
-	Create(ctx context.Context, uid uuid.UUID, text string, exp_time int) (*Note, error)
+	Create(ctx context.Context, n *Note) (*Note, error)
 	Get(ctx context.Context, uid uuid.UUID) (*Note, error)
-	List(ctx context.Context) ([]*Note, error)
-	Update(ctx context.Context, uid uuid.UUID, newText string) (*Note, error)
-	Delete(ctx context.Context, uid uuid.UUID) (*Note, error)
+	// View counts one view of the note and returns it, the note is deleted
+	// once it runs out of views.
+	View(ctx context.Context, uid uuid.UUID) (*Note, error)
+	List(ctx context.Context, f ListFilter) ([]*Note, string, error)
+	// Update replaces the content of the note, that is its Text, Nonce,
+	// Algorithm and the at-rest KeyID and DataKey. Update and Delete only
+	// touch notes of owner, other notes count as missing.
+	Update(ctx context.Context, owner uuid.UUID, uid uuid.UUID, content *Note) (*Note, error)
+	// Swap replaces the content of the note whoever owns it, but only while
+	// its stored Text, KeyID and DataKey still match old. It reports whether
+	// the note was written.
+	Swap(ctx context.Context, uid uuid.UUID, old *Note, content *Note) (bool, error)
+	Delete(ctx context.Context, owner uuid.UUID, uid uuid.UUID) (*Note, error)
+	// Purge deletes the note whoever owns it.
+	Purge(ctx context.Context, uid uuid.UUID) (*Note, error)
+	// Reserve hides the note for lease and returns it with a lease token.
+	// Ack deletes a reserved note for good, as long as the lease holds, an
+	// unacknowledged note becomes visible again once the lease runs out.
+	Reserve(ctx context.Context, uid uuid.UUID, lease time.Duration) (*Note, uuid.UUID, error)
+	Ack(ctx context.Context, uid uuid.UUID, token uuid.UUID) (bool, error)
+	// FailAttempt records a wrong passphrase for the note and burns it once
+	// maxAttempts is reached, it reports whether the note was burnt.
+	FailAttempt(ctx context.Context, uid uuid.UUID, maxAttempts int) (bool, error)
 	ClearExpired(ctx context.Context) error
 }
 
+const (
+	pgExpiresAt   = "created+(expiration*interval '1 minute')"
+	pgNoteColumns = "id, owner_id, text, created, expiration, max_views, views_remaining, nonce, alg, key_id, data_key, pass_hash, failed_attempts"
+)
+
+func pgVisible(now time.Time) sq.Sqlizer {
+	return sq.And{
+		sq.Gt{pgExpiresAt: now},
+		sq.Or{sq.Eq{"views_remaining": nil}, sq.Gt{"views_remaining": 0}},
+		sq.Or{sq.Eq{"lease_until": nil}, sq.LtOrEq{"lease_until": now}},
+	}
+}
+
+func scanNote(row pgx.Row) (*Note, error) {
+	n := &Note{}
+	if err := row.Scan(&n.ID, &n.OwnerID, &n.Text, &n.Created, &n.Expiration, &n.MaxViews, &n.ViewsRemaining, &n.Nonce, &n.Algorithm, &n.KeyID, &n.DataKey, &n.PassHash, &n.FailedAttempts); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
 type NoteDB struct {
-	conn *pgx.Conn
+	pool *pgxpool.Pool
 }
 
 func (ndb *NoteDB) Get(ctx context.Context, uid uuid.UUID) (*Note, error) {
-	sql, args, err := sq.Select("id, text, created, expiration").From("notes").Where(sq.Eq{"id": uid}).
+	sql, args, err := sq.Select(pgNoteColumns).From("notes").
+		Where(sq.Eq{"id": uid}).Where(pgVisible(time.Now())).
 		PlaceholderFormat(sq.Dollar).ToSql()
 	if err != nil {
 		return nil, err
 	}
 
-	n := &Note{}
-	if err = ndb.conn.QueryRow(ctx, sql, args...).Scan(&n.ID, &n.Text, &n.Created, &n.Expiration); err != nil {
+	n, err := scanNote(ndb.pool.QueryRow(ctx, sql, args...))
+	if err != nil {
 		if err == pgx.ErrNoRows {
 			return nil, nil
 		}
@@ -45,105 +197,272 @@ func (ndb *NoteDB) Get(ctx context.Context, uid uuid.UUID) (*Note, error) {
 	return n, nil
 }
 
-func (ndb *NoteDB) List(ctx context.Context) ([]*Note, error) {
-	sql, args, err := sq.Select("id, text, created, expiration").From("notes").OrderBy("created").
+func (ndb *NoteDB) View(ctx context.Context, uid uuid.UUID) (*Note, error) {
+	sql, args, err := sq.Update("notes").Set("views_remaining", sq.Expr("views_remaining - 1")).
+		Where(sq.Eq{"id": uid}).Where(pgVisible(time.Now())).
+		Suffix("RETURNING " + pgNoteColumns).
 		PlaceholderFormat(sq.Dollar).ToSql()
 	if err != nil {
 		return nil, err
 	}
 
-	var notes []*Note
-	rows, err := ndb.conn.Query(ctx, sql, args...)
+	// unlimited notes keep a NULL counter, NULL - 1 leaves it NULL
+	n, err := scanNote(ndb.pool.QueryRow(ctx, sql, args...))
 	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
 		return nil, err
 	}
+
+	if n.Exhausted() {
+		// the note is already hidden from every read, the delete only
+		// reclaims the row
+		sql, args, err = sq.Delete("notes").Where(sq.Eq{"id": uid, "views_remaining": 0}).
+			PlaceholderFormat(sq.Dollar).ToSql()
+		if err != nil {
+			return nil, err
+		}
+		if _, err = ndb.pool.Exec(ctx, sql, args...); err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+func (ndb *NoteDB) FailAttempt(ctx context.Context, uid uuid.UUID, maxAttempts int) (bool, error) {
+	sql, args, err := sq.Update("notes").Set("failed_attempts", sq.Expr("failed_attempts + 1")).
+		Where(sq.Eq{"id": uid}).Where(pgVisible(time.Now())).
+		Suffix("RETURNING failed_attempts").
+		PlaceholderFormat(sq.Dollar).ToSql()
+	if err != nil {
+		return false, err
+	}
+
+	var attempts int
+	if err = ndb.pool.QueryRow(ctx, sql, args...).Scan(&attempts); err != nil {
+		if err == pgx.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	if attempts < maxAttempts {
+		return false, nil
+	}
+
+	sql, args, err = sq.Delete("notes").Where(sq.Eq{"id": uid}).
+		PlaceholderFormat(sq.Dollar).ToSql()
+	if err != nil {
+		return false, err
+	}
+	if _, err = ndb.pool.Exec(ctx, sql, args...); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (ndb *NoteDB) List(ctx context.Context, f ListFilter) ([]*Note, string, error) {
+	c, err := f.cursor()
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	q := sq.Select(pgNoteColumns).From("notes").Where(pgVisible(now)).
+		OrderBy("created", "id").Limit(uint64(f.PageSize() + 1))
+	if !f.AllOwners {
+		q = q.Where(sq.Eq{"owner_id": f.Owner})
+	}
+	if c != nil {
+		q = q.Where("(created, id) > (?, ?)", c.created, c.id)
+	}
+	if !f.CreatedBefore.IsZero() {
+		q = q.Where(sq.Lt{"created": f.CreatedBefore})
+	}
+	if !f.CreatedAfter.IsZero() {
+		q = q.Where(sq.Gt{"created": f.CreatedAfter})
+	}
+	if f.ExpiringWithin > 0 {
+		q = q.Where(sq.LtOrEq{pgExpiresAt: now.Add(f.ExpiringWithin)})
+	}
+	sql, args, err := q.PlaceholderFormat(sq.Dollar).ToSql()
+	if err != nil {
+		return nil, "", err
+	}
+
+	var notes []*Note
+	rows, err := ndb.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, "", err
+	}
 	defer rows.Close()
 
 	for rows.Next() {
-		n := &Note{}
-		if err = rows.Scan(&n.ID, &n.Text, &n.Created, &n.Expiration); err != nil {
-			return nil, err
+		n, err := scanNote(rows)
+		if err != nil {
+			return nil, "", err
 		}
 		notes = append(notes, n)
 	}
+	if err = rows.Err(); err != nil {
+		return nil, "", err
+	}
 
-	return notes, nil
+	notes, next := f.page(notes)
+	return notes, next, nil
 }
 
-func (ndb *NoteDB) Update(ctx context.Context, uid uuid.UUID, newText string) (*Note, error) {
-	sql, args, err := sq.Update("notes").Set("text", newText).Where(sq.Eq{"id": uid}).
-		Suffix("RETURNING created").
+func (ndb *NoteDB) Update(ctx context.Context, owner uuid.UUID, uid uuid.UUID, content *Note) (*Note, error) {
+	sql, args, err := sq.Update("notes").
+		Set("text", content.Text).Set("nonce", content.Nonce).Set("alg", content.Algorithm).
+		Set("key_id", content.KeyID).Set("data_key", content.DataKey).
+		Where(sq.Eq{"id": uid, "owner_id": owner}).Where(pgVisible(time.Now())).
+		Suffix("RETURNING " + pgNoteColumns).
 		PlaceholderFormat(sq.Dollar).ToSql()
 	if err != nil {
 		return nil, err
 	}
 
-	n := &Note{
-		ID:   uid,
-		Text: newText,
-	}
-	if err = ndb.conn.QueryRow(ctx, sql, args...).Scan(&n.Created); err != nil {
+	n, err := scanNote(ndb.pool.QueryRow(ctx, sql, args...))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrNotFound
+		}
 		return nil, err
 	}
-	return n, err
+	return n, nil
 }
 
-func (ndb *NoteDB) Delete(ctx context.Context, uid uuid.UUID) (*Note, error) {
-	sql, args, err := sq.Delete("notes").Where(sq.Eq{"id": uid}).Suffix("RETURNING id, text, created").
+func (ndb *NoteDB) Swap(ctx context.Context, uid uuid.UUID, old *Note, content *Note) (bool, error) {
+	sql, args, err := sq.Update("notes").
+		Set("text", content.Text).Set("nonce", content.Nonce).Set("alg", content.Algorithm).
+		Set("key_id", content.KeyID).Set("data_key", content.DataKey).
+		Where(sq.Eq{"id": uid, "text": old.Text, "key_id": old.KeyID, "data_key": old.DataKey}).
+		Where(pgVisible(time.Now())).
+		PlaceholderFormat(sq.Dollar).ToSql()
+	if err != nil {
+		return false, err
+	}
+
+	tag, err := ndb.pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (ndb *NoteDB) Delete(ctx context.Context, owner uuid.UUID, uid uuid.UUID) (*Note, error) {
+	return ndb.delete(ctx, sq.Eq{"id": uid, "owner_id": owner})
+}
+
+func (ndb *NoteDB) Purge(ctx context.Context, uid uuid.UUID) (*Note, error) {
+	return ndb.delete(ctx, sq.Eq{"id": uid})
+}
+
+func (ndb *NoteDB) delete(ctx context.Context, where sq.Eq) (*Note, error) {
+	sql, args, err := sq.Delete("notes").Where(where).
+		Suffix("RETURNING " + pgNoteColumns).
 		PlaceholderFormat(sq.Dollar).ToSql()
 	if err != nil {
 		return nil, err
 	}
 
-	n := &Note{}
-	if err = ndb.conn.QueryRow(ctx, sql, args...).Scan(&n.ID, &n.Text, &n.Created); err != nil {
+	n, err := scanNote(ndb.pool.QueryRow(ctx, sql, args...))
+	if err != nil {
 		if err == pgx.ErrNoRows {
 			return nil, nil
 		}
 		return nil, err
 	}
+	// an expired or used up note is deleted all the same, but never handed out
+	if !n.visible(time.Now()) {
+		return nil, nil
+	}
 
 	return n, nil
 }
 
-func NewNoteDB(conn *pgx.Conn) NoteHandler {
-	return &NoteDB{conn: conn}
-}
+func (ndb *NoteDB) Reserve(ctx context.Context, uid uuid.UUID, lease time.Duration) (*Note, uuid.UUID, error) {
+	token, err := uuid.NewV4()
+	if err != nil {
+		return nil, uuid.Nil, err
+	}
 
-func (ndb *NoteDB) Create(ctx context.Context, uid uuid.UUID, text string, exp_time int) (*Note, error) {
 	now := time.Now()
+	sql, args, err := sq.Update("notes").Set("lease_token", token).Set("lease_until", now.Add(lease)).
+		Where(sq.Eq{"id": uid}).Where(pgVisible(now)).
+		Suffix("RETURNING " + pgNoteColumns).
+		PlaceholderFormat(sq.Dollar).ToSql()
+	if err != nil {
+		return nil, uuid.Nil, err
+	}
+
+	n, err := scanNote(ndb.pool.QueryRow(ctx, sql, args...))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, uuid.Nil, nil
+		}
+		return nil, uuid.Nil, err
+	}
+	return n, token, nil
+}
+
+func (ndb *NoteDB) Ack(ctx context.Context, uid uuid.UUID, token uuid.UUID) (bool, error) {
+	sql, args, err := sq.Delete("notes").
+		Where(sq.Eq{"id": uid, "lease_token": token}).Where(sq.Gt{"lease_until": time.Now()}).
+		PlaceholderFormat(sq.Dollar).ToSql()
+	if err != nil {
+		return false, err
+	}
+
+	tag, err := ndb.pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func NewNoteDB(pool *pgxpool.Pool) NoteHandler {
+	return &NoteDB{pool: pool}
+}
+
+func (ndb *NoteDB) Create(ctx context.Context, n *Note) (*Note, error) {
+	note := newNote(n)
 	query, args, err := sq.Insert("notes").
 		SetMap(map[string]interface{}{
-			"id":         uid,
-			"text":       text,
-			"created":    now,
-			"expiration": exp_time,
+			"id":              note.ID,
+			"owner_id":        note.OwnerID,
+			"text":            note.Text,
+			"created":         note.Created,
+			"expiration":      note.Expiration,
+			"max_views":       note.MaxViews,
+			"views_remaining": note.ViewsRemaining,
+			"nonce":           note.Nonce,
+			"alg":             note.Algorithm,
+			"key_id":          note.KeyID,
+			"data_key":        note.DataKey,
+			"pass_hash":       note.PassHash,
 		}).PlaceholderFormat(sq.Dollar).ToSql()
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = ndb.conn.Exec(ctx, query, args...)
+	_, err = ndb.pool.Exec(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Note{
-		ID:         uid,
-		Text:       text,
-		Created:    now,
-		Expiration: exp_time,
-	}, nil
+	return note, nil
 }
 
 func (ndb *NoteDB) ClearExpired(ctx context.Context) error {
-	sql, args, err := sq.Delete("notes").Where(sq.Lt{"created+(expiration*interval '1 minute')": time.Now()}).
+	sql, args, err := sq.Delete("notes").Where(sq.Lt{pgExpiresAt: time.Now()}).
 		PlaceholderFormat(sq.Dollar).ToSql()
 	if err != nil {
 		return err
 	}
 
-	_, err = ndb.conn.Exec(ctx, sql, args...)
+	_, err = ndb.pool.Exec(ctx, sql, args...)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,71 @@
+package db_test
+
+import (
+	"context"
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/pimka/go-onenote/db"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestPostgresDB runs the shared handler tests against Postgres, it needs a
+// server at DATABASE_URL (see docker-compose.yml) and is skipped without one.
+// Every run migrates a schema of its own and drops it afterwards.
+func TestPostgresDB(t *testing.T) {
+	dbURL := os.Getenv("DATABASE_URL")
+	if dbURL == "" {
+		t.Skip("DATABASE_URL is not set")
+	}
+	ctx := context.Background()
+	admin, err := pgxpool.Connect(ctx, dbURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+
+	schema := "onenote_test_" + strings.ReplaceAll(uuid.Must(uuid.NewV4()).String(), "-", "")
+	if _, err = admin.Exec(ctx, "CREATE SCHEMA "+schema); err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Exec(ctx, "DROP SCHEMA "+schema+" CASCADE")
+
+	u, err := url.Parse(dbURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	query.Set("search_path", schema)
+	u.RawQuery = query.Encode()
+	conf := db.Config{
+		Driver:          db.DriverPostgres,
+		URL:             u.String(),
+		MinConns:        1,
+		MaxConns:        4,
+		MaxConnLifetime: time.Hour,
+	}
+	if err = db.Migrate(conf); err != nil {
+		t.Fatal(err)
+	}
+
+	storage, err := db.Open(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	nh := storage.Notes
+
+	testNoteHandler(t, nh)
+	testNoteHandlerPages(t, nh)
+	testNoteHandlerViews(t, nh)
+	testNoteHandlerAttempts(t, nh)
+	testNoteHandlerOwners(t, nh)
+	testNoteHandlerSwap(t, nh)
+	testNoteHandlerLeases(t, nh)
+	testUserHandler(t, storage.Users)
+	testTokenHandler(t, storage.Tokens, storage.Users)
+	testShareHandler(t, storage.Shares, storage.Notes)
+}
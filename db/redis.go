@@ -0,0 +1,409 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gofrs/uuid"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"time"
+)
+
+const (
+	redisNotePrefix  = "note:"
+	redisLeasePrefix = "lease:"
+	redisCreatedKey  = "notes:created"
+	redisDeadlineKey = "notes:deadline"
+	redisTxRetries   = 10
+)
+
+// RedisDB stores every note as a JSON value whose key TTL is the note's
+// expiration, so Redis drops expired notes by itself. Two sorted sets keep
+// the ids ordered by creation time (for List) and by deadline (to prune the
+// index once the keys are gone).
+type RedisDB struct {
+	client *redis.Client
+}
+
+func OpenRedis(url string) (*redis.Client, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+
+	client := redis.NewClient(opts)
+	if err = client.Ping(context.Background()).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+func NewRedisDB(client *redis.Client) NoteHandler {
+	return &RedisDB{client: client}
+}
+
+func redisNoteKey(uid uuid.UUID) string {
+	return redisNotePrefix + uid.String()
+}
+
+// A lease lives in its own key next to the note, Redis drops it when the
+// lease runs out and the note becomes visible again.
+func redisLeaseKey(uid uuid.UUID) string {
+	return redisLeasePrefix + uid.String()
+}
+
+var redisReserveScript = redis.NewScript(`
+local data = redis.call('GET', KEYS[1])
+if not data then
+	return false
+end
+if not redis.call('SET', KEYS[2], ARGV[1], 'NX', 'PX', ARGV[2]) then
+	return false
+end
+return data
+`)
+
+var redisAckScript = redis.NewScript(`
+if redis.call('GET', KEYS[2]) ~= ARGV[1] then
+	return 0
+end
+redis.call('DEL', KEYS[1], KEYS[2])
+redis.call('ZREM', KEYS[3], ARGV[2])
+redis.call('ZREM', KEYS[4], ARGV[2])
+return 1
+`)
+
+var redisDeleteScript = redis.NewScript(`
+local data = redis.call('GET', KEYS[1])
+if not data then
+	return false
+end
+if ARGV[1] ~= '' and cjson.decode(data)['owner_id'] ~= ARGV[1] then
+	return false
+end
+redis.call('DEL', KEYS[1], KEYS[2])
+redis.call('ZREM', KEYS[3], ARGV[2])
+redis.call('ZREM', KEYS[4], ARGV[2])
+return data
+`)
+
+func redisTTL(n *Note) time.Duration {
+	ttl := time.Until(n.ExpiresAt())
+	if ttl < time.Millisecond {
+		// a zero TTL means "keep forever" to Redis
+		ttl = time.Millisecond
+	}
+	return ttl
+}
+
+func (r *RedisDB) Create(ctx context.Context, n *Note) (*Note, error) {
+	note := newNote(n)
+	// the created index has microsecond scores, keep the note in step with
+	// it so cursors line up with the index order
+	note.Created = note.Created.Truncate(time.Microsecond)
+	data, err := json.Marshal(note)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, redisNoteKey(note.ID), data, redisTTL(note))
+		pipe.ZAdd(ctx, redisCreatedKey, redis.Z{Score: float64(note.Created.UnixMicro()), Member: note.ID.String()})
+		pipe.ZAdd(ctx, redisDeadlineKey, redis.Z{Score: float64(note.ExpiresAt().UnixMicro()), Member: note.ID.String()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return note, nil
+}
+
+func (r *RedisDB) Get(ctx context.Context, uid uuid.UUID) (*Note, error) {
+	values, err := r.client.MGet(ctx, redisNoteKey(uid), redisLeaseKey(uid)).Result()
+	if err != nil {
+		return nil, err
+	}
+	data, ok := values[0].(string)
+	if !ok || values[1] != nil {
+		return nil, nil
+	}
+	return decodeLiveRedisNote([]byte(data))
+}
+
+func (r *RedisDB) List(ctx context.Context, f ListFilter) ([]*Note, string, error) {
+	c, err := f.cursor()
+	if err != nil {
+		return nil, "", err
+	}
+
+	min, max := "-inf", "+inf"
+	if c != nil {
+		min = formatScore(c.created.UnixMicro())
+	}
+	if !f.CreatedAfter.IsZero() && (c == nil || f.CreatedAfter.After(c.created)) {
+		min = formatScore(f.CreatedAfter.UnixMicro())
+	}
+	if !f.CreatedBefore.IsZero() {
+		max = formatScore(f.CreatedBefore.UnixMicro())
+	}
+
+	size := f.PageSize()
+	batch := int64(size + 1)
+	now := time.Now()
+	var notes []*Note
+	for offset := int64(0); len(notes) <= size; offset += batch {
+		ids, err := r.client.ZRangeByScore(ctx, redisCreatedKey, &redis.ZRangeBy{
+			Min:    min,
+			Max:    max,
+			Offset: offset,
+			Count:  batch,
+		}).Result()
+		if err != nil {
+			return nil, "", err
+		}
+		if len(ids) == 0 {
+			break
+		}
+
+		// notes first, then their leases
+		keys := make([]string, 2*len(ids))
+		for i, id := range ids {
+			keys[i] = redisNotePrefix + id
+			keys[len(ids)+i] = redisLeasePrefix + id
+		}
+		values, err := r.client.MGet(ctx, keys...).Result()
+		if err != nil {
+			return nil, "", err
+		}
+
+		for i, v := range values[:len(ids)] {
+			data, ok := v.(string)
+			if !ok || values[len(ids)+i] != nil {
+				continue
+			}
+			n, err := decodeRedisNote([]byte(data))
+			if err != nil {
+				return nil, "", err
+			}
+			if c.before(n) && f.match(n, now) {
+				notes = append(notes, n)
+			}
+		}
+		if int64(len(ids)) < batch {
+			break
+		}
+	}
+
+	notes, next := f.page(notes)
+	return notes, next, nil
+}
+
+func (r *RedisDB) Update(ctx context.Context, owner uuid.UUID, uid uuid.UUID, content *Note) (*Note, error) {
+	return r.modify(ctx, uid, func(n *Note) (bool, error) {
+		if !n.ownedBy(owner) {
+			return false, ErrNotFound
+		}
+		n.setContent(content)
+		return false, nil
+	})
+}
+
+func (r *RedisDB) Swap(ctx context.Context, uid uuid.UUID, old *Note, content *Note) (bool, error) {
+	_, err := r.modify(ctx, uid, func(n *Note) (bool, error) {
+		if !n.sameContent(old) {
+			return false, ErrNotFound
+		}
+		n.setContent(content)
+		return false, nil
+	})
+	if err == ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (r *RedisDB) View(ctx context.Context, uid uuid.UUID) (*Note, error) {
+	note, err := r.modify(ctx, uid, func(n *Note) (bool, error) {
+		n.countView()
+		return n.Exhausted(), nil
+	})
+	if err == ErrNotFound {
+		return nil, nil
+	}
+	return note, err
+}
+
+func (r *RedisDB) FailAttempt(ctx context.Context, uid uuid.UUID, maxAttempts int) (bool, error) {
+	burnt := false
+	_, err := r.modify(ctx, uid, func(n *Note) (bool, error) {
+		n.FailedAttempts++
+		burnt = n.FailedAttempts >= maxAttempts
+		return burnt, nil
+	})
+	if err == ErrNotFound {
+		return false, nil
+	}
+	return burnt, err
+}
+
+// modify applies fn to the stored note inside a WATCH transaction and writes
+// it back with its TTL intact, or deletes it when fn returns true. An error
+// from fn aborts the transaction. Transactions that lose a race are retried.
+func (r *RedisDB) modify(ctx context.Context, uid uuid.UUID, fn func(n *Note) (bool, error)) (*Note, error) {
+	key, leaseKey := redisNoteKey(uid), redisLeaseKey(uid)
+	var note *Note
+	txf := func(tx *redis.Tx) error {
+		values, err := tx.MGet(ctx, key, leaseKey).Result()
+		if err != nil {
+			return err
+		}
+		data, ok := values[0].(string)
+		if !ok || values[1] != nil {
+			return ErrNotFound
+		}
+		n, err := decodeLiveRedisNote([]byte(data))
+		if err != nil {
+			return err
+		}
+		if n == nil {
+			return ErrNotFound
+		}
+		remove, err := fn(n)
+		if err != nil {
+			return err
+		}
+		updated, err := json.Marshal(n)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if remove {
+				pipe.Del(ctx, key)
+				pipe.ZRem(ctx, redisCreatedKey, uid.String())
+				pipe.ZRem(ctx, redisDeadlineKey, uid.String())
+				return nil
+			}
+			pipe.SetArgs(ctx, key, updated, redis.SetArgs{KeepTTL: true})
+			return nil
+		})
+		note = n
+		return err
+	}
+
+	for i := 0; i < redisTxRetries; i++ {
+		err := r.client.Watch(ctx, txf, key, leaseKey)
+		if err == redis.TxFailedErr {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return note, nil
+	}
+	return nil, redis.TxFailedErr
+}
+
+// Delete removes the note only if it belongs to owner, the script compares
+// the owner_id of the stored JSON so the check and the delete are atomic.
+func (r *RedisDB) Delete(ctx context.Context, owner uuid.UUID, uid uuid.UUID) (*Note, error) {
+	return r.delete(ctx, owner.String(), uid)
+}
+
+func (r *RedisDB) Purge(ctx context.Context, uid uuid.UUID) (*Note, error) {
+	return r.delete(ctx, "", uid)
+}
+
+// delete runs the delete script, an empty owner matches every note.
+func (r *RedisDB) delete(ctx context.Context, owner string, uid uuid.UUID) (*Note, error) {
+	data, err := redisDeleteScript.Run(ctx, r.client,
+		[]string{redisNoteKey(uid), redisLeaseKey(uid), redisCreatedKey, redisDeadlineKey},
+		owner, uid.String()).Text()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return decodeLiveRedisNote([]byte(data))
+}
+
+func (r *RedisDB) Reserve(ctx context.Context, uid uuid.UUID, lease time.Duration) (*Note, uuid.UUID, error) {
+	token, err := uuid.NewV4()
+	if err != nil {
+		return nil, uuid.Nil, err
+	}
+
+	data, err := redisReserveScript.Run(ctx, r.client,
+		[]string{redisNoteKey(uid), redisLeaseKey(uid)},
+		token.String(), lease.Milliseconds()).Text()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, uuid.Nil, nil
+		}
+		return nil, uuid.Nil, err
+	}
+
+	n, err := decodeLiveRedisNote([]byte(data))
+	if err != nil || n == nil {
+		return nil, uuid.Nil, err
+	}
+	return n, token, nil
+}
+
+func (r *RedisDB) Ack(ctx context.Context, uid uuid.UUID, token uuid.UUID) (bool, error) {
+	acked, err := redisAckScript.Run(ctx, r.client,
+		[]string{redisNoteKey(uid), redisLeaseKey(uid), redisCreatedKey, redisDeadlineKey},
+		token.String(), uid.String()).Int()
+	if err != nil {
+		return false, err
+	}
+	return acked == 1, nil
+}
+
+// ClearExpired doesn't touch notes, Redis expires their keys. It only drops
+// the ids of expired notes from the indexes.
+func (r *RedisDB) ClearExpired(ctx context.Context) error {
+	max := formatScore(time.Now().UnixMicro())
+	ids, err := r.client.ZRangeByScore(ctx, redisDeadlineKey, &redis.ZRangeBy{Min: "-inf", Max: max}).Result()
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	members := make([]interface{}, len(ids))
+	for i, id := range ids {
+		members[i] = id
+	}
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRem(ctx, redisCreatedKey, members...)
+		pipe.ZRem(ctx, redisDeadlineKey, members...)
+		return nil
+	})
+	return err
+}
+
+func decodeRedisNote(data []byte) (*Note, error) {
+	n := &Note{}
+	if err := json.Unmarshal(data, n); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// decodeLiveRedisNote returns nil for a note that is past its deadline but
+// whose key Redis hasn't evicted yet.
+func decodeLiveRedisNote(data []byte) (*Note, error) {
+	n, err := decodeRedisNote(data)
+	if err != nil || !n.visible(time.Now()) {
+		return nil, err
+	}
+	return n, nil
+}
+
+func formatScore(score int64) string {
+	return strconv.FormatInt(score, 10)
+}
@@ -0,0 +1,161 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gofrs/uuid"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+const (
+	redisSharePrefix      = "share:"
+	redisNoteSharesPrefix = "note-shares:"
+)
+
+// RedisShareDB keeps every link as a JSON value whose key TTL is the link's
+// expiry, next to a set of link IDs per note. ListShares drops the IDs of
+// links whose keys are gone.
+type RedisShareDB struct {
+	client *redis.Client
+}
+
+func NewRedisShareDB(client *redis.Client) ShareHandler {
+	return &RedisShareDB{client: client}
+}
+
+var redisUseShareScript = redis.NewScript(`
+local data = redis.call('GET', KEYS[1])
+if not data then
+	return false
+end
+local link = cjson.decode(data)
+local views = link['views_remaining']
+if views ~= nil and views ~= cjson.null then
+	if views <= 0 then
+		return false
+	end
+	link['views_remaining'] = views - 1
+	data = cjson.encode(link)
+	redis.call('SET', KEYS[1], data, 'KEEPTTL')
+end
+return data
+`)
+
+func (r *RedisShareDB) CreateShare(ctx context.Context, l *ShareLink) (*ShareLink, error) {
+	link := newShareLink(l)
+	data, err := json.Marshal(link)
+	if err != nil {
+		return nil, err
+	}
+	ttl := time.Until(link.ExpiresAt)
+	if ttl < time.Millisecond {
+		ttl = time.Millisecond
+	}
+
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, redisSharePrefix+link.ID.String(), data, ttl)
+		pipe.SAdd(ctx, redisNoteSharesPrefix+link.NoteID.String(), link.ID.String())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+func (r *RedisShareDB) getShare(ctx context.Context, id string) (*ShareLink, error) {
+	data, err := r.client.Get(ctx, redisSharePrefix+id).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return decodeLiveRedisShare(data)
+}
+
+func (r *RedisShareDB) GetShare(ctx context.Context, id uuid.UUID) (*ShareLink, error) {
+	return r.getShare(ctx, id.String())
+}
+
+func (r *RedisShareDB) ListShares(ctx context.Context, noteID uuid.UUID) ([]*ShareLink, error) {
+	ids, err := r.client.SMembers(ctx, redisNoteSharesPrefix+noteID.String()).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var links []*ShareLink
+	var gone []interface{}
+	for _, id := range ids {
+		data, err := r.client.Get(ctx, redisSharePrefix+id).Bytes()
+		if err == redis.Nil {
+			gone = append(gone, id)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		l, err := decodeLiveRedisShare(data)
+		if err != nil {
+			return nil, err
+		}
+		if l != nil {
+			links = append(links, l)
+		}
+	}
+	if len(gone) > 0 {
+		if err = r.client.SRem(ctx, redisNoteSharesPrefix+noteID.String(), gone...).Err(); err != nil {
+			return nil, err
+		}
+	}
+	sortShares(links)
+	return links, nil
+}
+
+func (r *RedisShareDB) UseShare(ctx context.Context, id uuid.UUID) (*ShareLink, error) {
+	data, err := redisUseShareScript.Run(ctx, r.client, []string{redisSharePrefix + id.String()}).Text()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, nil
+		}
+		return nil, err
+	}
+	l := &ShareLink{}
+	if err = json.Unmarshal([]byte(data), l); err != nil {
+		return nil, err
+	}
+	// the key may still be around for a moment past the link's expiry
+	if !l.ExpiresAt.After(time.Now()) {
+		return nil, nil
+	}
+	return l, nil
+}
+
+func (r *RedisShareDB) RevokeShare(ctx context.Context, noteID, id uuid.UUID) (bool, error) {
+	l, err := r.getShare(ctx, id.String())
+	if err != nil || l == nil || l.NoteID != noteID {
+		return false, err
+	}
+
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, redisSharePrefix+id.String())
+		pipe.SRem(ctx, redisNoteSharesPrefix+noteID.String(), id.String())
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func decodeLiveRedisShare(data []byte) (*ShareLink, error) {
+	l := &ShareLink{}
+	if err := json.Unmarshal(data, l); err != nil {
+		return nil, err
+	}
+	if !l.Usable(time.Now()) {
+		return nil, nil
+	}
+	return l, nil
+}
@@ -0,0 +1,97 @@
+package db_test
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gofrs/uuid"
+	"github.com/pimka/go-onenote/db"
+	"testing"
+	"time"
+)
+
+func openMiniRedis(t *testing.T) (*miniredis.Miniredis, *db.Storage) {
+	mr := miniredis.RunT(t)
+	storage, err := db.Open(db.Config{Driver: db.DriverRedis, RedisURL: "redis://" + mr.Addr()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(storage.Close)
+	return mr, storage
+}
+
+func TestRedisDB(t *testing.T) {
+	_, storage := openMiniRedis(t)
+	nh := storage.Notes
+	testNoteHandler(t, nh)
+	testNoteHandlerPages(t, nh)
+	testNoteHandlerViews(t, nh)
+	testNoteHandlerAttempts(t, nh)
+	testNoteHandlerOwners(t, nh)
+	testNoteHandlerSwap(t, nh)
+	testUserHandler(t, storage.Users)
+	testTokenHandler(t, storage.Tokens, storage.Users)
+	testShareHandler(t, storage.Shares, storage.Notes)
+}
+
+func TestRedisDB_TTL(t *testing.T) {
+	mr, storage := openMiniRedis(t)
+	nh := storage.Notes
+	ctx := context.Background()
+
+	uid, _ := uuid.NewV4()
+	if _, err := nh.Create(ctx, &db.Note{ID: uid, Text: "test", Expiration: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := mr.TTL("note:" + uid.String()); ttl <= 0 || ttl > time.Minute {
+		t.Fatalf("unexpected ttl %v", ttl)
+	}
+
+	if _, err := nh.Update(ctx, uuid.Nil, uid, &db.Note{Text: "new test"}); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := mr.TTL("note:" + uid.String()); ttl <= 0 {
+		t.Fatal("update dropped the ttl")
+	}
+
+	mr.FastForward(time.Minute)
+	n, err := nh.Get(ctx, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != nil {
+		t.Fatal("note outlived its ttl")
+	}
+}
+
+func TestRedisDB_Lease(t *testing.T) {
+	mr, storage := openMiniRedis(t)
+	nh := storage.Notes
+	ctx := context.Background()
+
+	uid, _ := uuid.NewV4()
+	if _, err := nh.Create(ctx, &db.Note{ID: uid, Text: "pop me", Expiration: 10}); err != nil {
+		t.Fatal(err)
+	}
+	n, token, err := nh.Reserve(ctx, uid, time.Second)
+	if err != nil || n == nil {
+		t.Fatal("RESERVE didn't lease the note")
+	}
+	if n, _ = nh.Get(ctx, uid); n != nil {
+		t.Fatal("GET returned a reserved note")
+	}
+
+	mr.FastForward(time.Second)
+	if acked, _ := nh.Ack(ctx, uid, token); acked {
+		t.Fatal("ACK accepted an expired lease")
+	}
+	if n, token, _ = nh.Reserve(ctx, uid, time.Second); n == nil {
+		t.Fatal("note didn't come back after its lease ran out")
+	}
+	acked, err := nh.Ack(ctx, uid, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !acked || mr.Exists("note:"+uid.String()) {
+		t.Fatal("ACK didn't delete the note")
+	}
+}
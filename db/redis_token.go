@@ -0,0 +1,134 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gofrs/uuid"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+const (
+	redisTokenPrefix      = "pat:"
+	redisTokenHashPrefix  = "pat-hash:"
+	redisUserTokensPrefix = "user-pats:"
+)
+
+// RedisTokenDB keeps every token as a JSON value, with a key from its hash to
+// its ID and a set of token IDs per user.
+type RedisTokenDB struct {
+	client *redis.Client
+}
+
+func NewRedisTokenDB(client *redis.Client) TokenHandler {
+	return &RedisTokenDB{client: client}
+}
+
+func (r *RedisTokenDB) CreateToken(ctx context.Context, t *AccessToken) (*AccessToken, error) {
+	token := newAccessToken(t)
+	data, err := json.Marshal(token)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, redisTokenPrefix+token.ID.String(), data, 0)
+		pipe.Set(ctx, redisTokenHashPrefix+token.Hash, token.ID.String(), 0)
+		pipe.SAdd(ctx, redisUserTokensPrefix+token.UserID.String(), token.ID.String())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func (r *RedisTokenDB) getToken(ctx context.Context, id string) (*AccessToken, error) {
+	data, err := r.client.Get(ctx, redisTokenPrefix+id).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, nil
+		}
+		return nil, err
+	}
+	t := &AccessToken{}
+	if err = json.Unmarshal(data, t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (r *RedisTokenDB) GetTokenByHash(ctx context.Context, hash string) (*AccessToken, error) {
+	id, err := r.client.Get(ctx, redisTokenHashPrefix+hash).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return r.getToken(ctx, id)
+}
+
+func (r *RedisTokenDB) ListTokens(ctx context.Context, userID uuid.UUID) ([]*AccessToken, error) {
+	ids, err := r.client.SMembers(ctx, redisUserTokensPrefix+userID.String()).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var tokens []*AccessToken
+	for _, id := range ids {
+		t, err := r.getToken(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if t != nil {
+			tokens = append(tokens, t)
+		}
+	}
+	sortTokens(tokens)
+	return tokens, nil
+}
+
+func (r *RedisTokenDB) RevokeToken(ctx context.Context, userID, id uuid.UUID) (bool, error) {
+	t, err := r.getToken(ctx, id.String())
+	if err != nil || t == nil || t.UserID != userID {
+		return false, err
+	}
+
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, redisTokenPrefix+id.String(), redisTokenHashPrefix+t.Hash)
+		pipe.SRem(ctx, redisUserTokensPrefix+userID.String(), id.String())
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *RedisTokenDB) TouchToken(ctx context.Context, id uuid.UUID, at time.Time) error {
+	key := redisTokenPrefix + id.String()
+	txf := func(tx *redis.Tx) error {
+		t, err := r.getToken(ctx, id.String())
+		if err != nil || t == nil {
+			return err
+		}
+		t.LastUsed = &at
+		data, err := json.Marshal(t)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.SetArgs(ctx, key, data, redis.SetArgs{Mode: "XX"})
+			return nil
+		})
+		return err
+	}
+
+	// last used is a hint, losing a race to a concurrent touch is fine
+	err := r.client.Watch(ctx, txf, key)
+	if err == redis.TxFailedErr {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,113 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gofrs/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	redisUserPrefix     = "user:"
+	redisUsernamePrefix = "username:"
+)
+
+// RedisUserDB keeps every user as a JSON value plus a username key pointing
+// at its ID, claimed with SETNX so usernames stay unique.
+type RedisUserDB struct {
+	client *redis.Client
+}
+
+func NewRedisUserDB(client *redis.Client) UserHandler {
+	return &RedisUserDB{client: client}
+}
+
+func (r *RedisUserDB) CreateUser(ctx context.Context, u *User) (*User, error) {
+	user := newUser(u)
+	data, err := json.Marshal(user)
+	if err != nil {
+		return nil, err
+	}
+
+	claimed, err := r.client.SetNX(ctx, redisUsernamePrefix+user.Username, user.ID.String(), 0).Result()
+	if err != nil {
+		return nil, err
+	}
+	if !claimed {
+		return nil, ErrUserExists
+	}
+	if err = r.client.Set(ctx, redisUserPrefix+user.ID.String(), data, 0).Err(); err != nil {
+		r.client.Del(ctx, redisUsernamePrefix+user.Username)
+		return nil, err
+	}
+	return user, nil
+}
+
+func (r *RedisUserDB) GetUser(ctx context.Context, uid uuid.UUID) (*User, error) {
+	data, err := r.client.Get(ctx, redisUserPrefix+uid.String()).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, nil
+		}
+		return nil, err
+	}
+	u := &User{}
+	if err = json.Unmarshal(data, u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func (r *RedisUserDB) GetUserByName(ctx context.Context, username string) (*User, error) {
+	id, err := r.client.Get(ctx, redisUsernamePrefix+username).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, nil
+		}
+		return nil, err
+	}
+	uid, err := uuid.FromString(id)
+	if err != nil {
+		return nil, err
+	}
+	return r.GetUser(ctx, uid)
+}
+
+// SetRole rewrites the user's JSON in a WATCH transaction, so it doesn't race
+// with another role change.
+func (r *RedisUserDB) SetRole(ctx context.Context, uid uuid.UUID, role string) (bool, error) {
+	key := redisUserPrefix + uid.String()
+	found := false
+	txf := func(tx *redis.Tx) error {
+		data, err := tx.Get(ctx, key).Bytes()
+		if err != nil {
+			if err == redis.Nil {
+				return nil
+			}
+			return err
+		}
+		u := &User{}
+		if err = json.Unmarshal(data, u); err != nil {
+			return err
+		}
+		u.Role = role
+		if data, err = json.Marshal(u); err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, data, 0)
+			return nil
+		})
+		found = err == nil
+		return err
+	}
+
+	for i := 0; i < redisTxRetries; i++ {
+		err := r.client.Watch(ctx, txf, key)
+		if err == redis.TxFailedErr {
+			continue
+		}
+		return found, err
+	}
+	return false, redis.TxFailedErr
+}
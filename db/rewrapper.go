@@ -0,0 +1,62 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// KeyRewrapper periodically re-wraps data keys left under an old master key
+// after a rotation, the same way NotePurger drives ClearExpired.
+type KeyRewrapper struct {
+	ch          *CryptHandler
+	off         chan struct{}
+	done        chan struct{}
+	timeout     time.Duration
+	maxErrCount int
+}
+
+func NewRewrapper(ch *CryptHandler, timeout time.Duration, maxErrCount int) *KeyRewrapper {
+	return &KeyRewrapper{
+		ch:          ch,
+		off:         make(chan struct{}, 1),
+		done:        make(chan struct{}, 1),
+		timeout:     timeout,
+		maxErrCount: maxErrCount,
+	}
+}
+
+func (r *KeyRewrapper) Rewrap(ctx context.Context) {
+	t := time.NewTicker(r.timeout)
+	go func() {
+		defer func() {
+			t.Stop()
+			close(r.done)
+		}()
+		errCount := 0
+
+		for {
+			select {
+			case <-t.C:
+				err := r.ch.Rewrap(ctx)
+				if err != nil {
+					errCount++
+				} else {
+					errCount = 0
+				}
+				if errCount >= r.maxErrCount {
+					return
+				}
+			case <-r.off:
+				return
+			}
+		}
+	}()
+}
+
+func (r *KeyRewrapper) Stop() chan<- struct{} {
+	return r.off
+}
+
+func (r *KeyRewrapper) Done() <-chan struct{} {
+	return r.done
+}
@@ -0,0 +1,226 @@
+package db
+
+import (
+	"context"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"sort"
+	"time"
+)
+
+const (
+	SharePermRead      = "read"
+	SharePermReadWrite = "read-write"
+)
+
+// ShareLink is the server side of a share link to a note. The link itself
+// is a signed token that never gets stored, the record is what lets a link
+// be revoked and counts its views.
+type ShareLink struct {
+	ID         uuid.UUID `json:"id"`
+	NoteID     uuid.UUID `json:"note_id"`
+	Permission string    `json:"permission"`
+	Created    time.Time `json:"created"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	// MaxViews limits how many times the note can be read through the
+	// link, 0 means no limit and leaves ViewsRemaining nil.
+	MaxViews       int  `json:"max_views,omitempty"`
+	ViewsRemaining *int `json:"views_remaining,omitempty"`
+	// WrappedKey is the key of the note text sealed under a key only the
+	// link token carries, so deleting the link takes the key with it.
+	WrappedKey string `json:"wrapped_key,omitempty"`
+}
+
+// Usable reports whether the link can still be used at now.
+func (l *ShareLink) Usable(now time.Time) bool {
+	return l.ExpiresAt.After(now) && (l.ViewsRemaining == nil || *l.ViewsRemaining > 0)
+}
+
+type ShareHandler interface {
+	// CreateShare stores a new link, expired links are dropped on the way.
+	CreateShare(ctx context.Context, l *ShareLink) (*ShareLink, error)
+	// GetShare returns the link if it is still usable.
+	GetShare(ctx context.Context, id uuid.UUID) (*ShareLink, error)
+	ListShares(ctx context.Context, noteID uuid.UUID) ([]*ShareLink, error)
+	// UseShare counts one view through the link and returns it, nil when the
+	// link is gone, expired or used up.
+	UseShare(ctx context.Context, id uuid.UUID) (*ShareLink, error)
+	// RevokeShare deletes a link of the note, it reports whether there was
+	// one.
+	RevokeShare(ctx context.Context, noteID, id uuid.UUID) (bool, error)
+}
+
+func newShareLink(l *ShareLink) *ShareLink {
+	link := &ShareLink{
+		ID:         l.ID,
+		NoteID:     l.NoteID,
+		Permission: l.Permission,
+		Created:    time.Now(),
+		ExpiresAt:  l.ExpiresAt,
+		WrappedKey: l.WrappedKey,
+	}
+	if l.MaxViews > 0 {
+		views := l.MaxViews
+		link.MaxViews = views
+		link.ViewsRemaining = &views
+	}
+	return link
+}
+
+func (l *ShareLink) clone() *ShareLink {
+	c := *l
+	if l.ViewsRemaining != nil {
+		views := *l.ViewsRemaining
+		c.ViewsRemaining = &views
+	}
+	return &c
+}
+
+// countView takes one view off a view limited link.
+func (l *ShareLink) countView() {
+	if l.ViewsRemaining != nil {
+		views := *l.ViewsRemaining - 1
+		l.ViewsRemaining = &views
+	}
+}
+
+func sortShares(links []*ShareLink) {
+	sort.Slice(links, func(i, j int) bool {
+		return links[i].Created.Before(links[j].Created)
+	})
+}
+
+const shareColumns = "id, note_id, permission, created, expires_at, max_views, views_remaining, wrapped_key"
+
+func shareUsable(now interface{}) sq.Sqlizer {
+	return sq.And{
+		sq.Gt{"expires_at": now},
+		sq.Or{sq.Eq{"views_remaining": nil}, sq.Gt{"views_remaining": 0}},
+	}
+}
+
+type ShareDB struct {
+	pool *pgxpool.Pool
+}
+
+func NewShareDB(pool *pgxpool.Pool) ShareHandler {
+	return &ShareDB{pool: pool}
+}
+
+func scanShare(row pgx.Row) (*ShareLink, error) {
+	l := &ShareLink{}
+	if err := row.Scan(&l.ID, &l.NoteID, &l.Permission, &l.Created, &l.ExpiresAt, &l.MaxViews, &l.ViewsRemaining, &l.WrappedKey); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (sdb *ShareDB) CreateShare(ctx context.Context, l *ShareLink) (*ShareLink, error) {
+	link := newShareLink(l)
+	query, args, err := sq.Delete("share_links").Where(sq.LtOrEq{"expires_at": link.Created}).
+		PlaceholderFormat(sq.Dollar).ToSql()
+	if err != nil {
+		return nil, err
+	}
+	if _, err = sdb.pool.Exec(ctx, query, args...); err != nil {
+		return nil, err
+	}
+
+	query, args, err = sq.Insert("share_links").
+		SetMap(map[string]interface{}{
+			"id":              link.ID,
+			"note_id":         link.NoteID,
+			"permission":      link.Permission,
+			"created":         link.Created,
+			"expires_at":      link.ExpiresAt,
+			"max_views":       link.MaxViews,
+			"views_remaining": link.ViewsRemaining,
+			"wrapped_key":     link.WrappedKey,
+		}).PlaceholderFormat(sq.Dollar).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = sdb.pool.Exec(ctx, query, args...); err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+func (sdb *ShareDB) GetShare(ctx context.Context, id uuid.UUID) (*ShareLink, error) {
+	query, args, err := sq.Select(shareColumns).From("share_links").
+		Where(sq.Eq{"id": id}).Where(shareUsable(time.Now())).
+		PlaceholderFormat(sq.Dollar).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := scanShare(sdb.pool.QueryRow(ctx, query, args...))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return l, nil
+}
+
+func (sdb *ShareDB) ListShares(ctx context.Context, noteID uuid.UUID) ([]*ShareLink, error) {
+	query, args, err := sq.Select(shareColumns).From("share_links").
+		Where(sq.Eq{"note_id": noteID}).Where(shareUsable(time.Now())).
+		OrderBy("created").PlaceholderFormat(sq.Dollar).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := sdb.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []*ShareLink
+	for rows.Next() {
+		l, err := scanShare(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, l)
+	}
+	return links, rows.Err()
+}
+
+func (sdb *ShareDB) UseShare(ctx context.Context, id uuid.UUID) (*ShareLink, error) {
+	query, args, err := sq.Update("share_links").Set("views_remaining", sq.Expr("views_remaining - 1")).
+		Where(sq.Eq{"id": id}).Where(shareUsable(time.Now())).
+		Suffix("RETURNING " + shareColumns).
+		PlaceholderFormat(sq.Dollar).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := scanShare(sdb.pool.QueryRow(ctx, query, args...))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return l, nil
+}
+
+func (sdb *ShareDB) RevokeShare(ctx context.Context, noteID, id uuid.UUID) (bool, error) {
+	query, args, err := sq.Delete("share_links").Where(sq.Eq{"id": id, "note_id": noteID}).
+		PlaceholderFormat(sq.Dollar).ToSql()
+	if err != nil {
+		return false, err
+	}
+
+	tag, err := sdb.pool.Exec(ctx, query, args...)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
@@ -0,0 +1,93 @@
+package db_test
+
+import (
+	"context"
+	"github.com/gofrs/uuid"
+	"github.com/pimka/go-onenote/db"
+	"testing"
+	"time"
+)
+
+func testShareHandler(t *testing.T, sh db.ShareHandler, nh db.NoteHandler) {
+	ctx := context.Background()
+
+	noteID, _ := uuid.NewV4()
+	if _, err := nh.Create(ctx, &db.Note{ID: noteID, Text: "shared", Expiration: 10}); err != nil {
+		t.Fatal(err)
+	}
+
+	id, _ := uuid.NewV4()
+	expires := time.Now().Add(time.Hour).Truncate(time.Microsecond)
+	link, err := sh.CreateShare(ctx, &db.ShareLink{
+		ID:         id,
+		NoteID:     noteID,
+		Permission: db.SharePermRead,
+		ExpiresAt:  expires,
+		MaxViews:   2,
+		WrappedKey: "d3JhcHBlZA==",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.ID != id || link.Created.IsZero() || link.ViewsRemaining == nil || *link.ViewsRemaining != 2 {
+		t.Fatal("CREATE returned another link")
+	}
+	other, _ := uuid.NewV4()
+	if _, err = sh.CreateShare(ctx, &db.ShareLink{ID: other, NoteID: noteID, Permission: db.SharePermReadWrite, ExpiresAt: expires}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := sh.GetShare(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.NoteID != noteID || got.Permission != db.SharePermRead || !got.ExpiresAt.Equal(expires) || got.WrappedKey != "d3JhcHBlZA==" {
+		t.Fatal("GET returned another link")
+	}
+
+	links, err := sh.ListShares(ctx, noteID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 2 || links[0].ID != id || links[1].ID != other || links[1].ViewsRemaining != nil {
+		t.Fatal("LIST returned other links")
+	}
+
+	for i := 1; i >= 0; i-- {
+		used, err := sh.UseShare(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if used == nil || *used.ViewsRemaining != i || used.WrappedKey != "d3JhcHBlZA==" {
+			t.Fatal("USE didn't count the view")
+		}
+	}
+	if used, err := sh.UseShare(ctx, id); err != nil || used != nil {
+		t.Fatal("USE went past the view limit")
+	}
+	if got, err = sh.GetShare(ctx, id); err != nil || got != nil {
+		t.Fatal("GET returned a used up link")
+	}
+	if used, err := sh.UseShare(ctx, other); err != nil || used == nil || used.ViewsRemaining != nil {
+		t.Fatal("USE limited a link without a view limit")
+	}
+
+	expired, _ := uuid.NewV4()
+	if _, err = sh.CreateShare(ctx, &db.ShareLink{ID: expired, NoteID: noteID, Permission: db.SharePermRead, ExpiresAt: time.Now().Add(-time.Second)}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = sh.GetShare(ctx, expired); err != nil || got != nil {
+		t.Fatal("GET returned an expired link")
+	}
+
+	stranger, _ := uuid.NewV4()
+	if revoked, err := sh.RevokeShare(ctx, stranger, other); err != nil || revoked {
+		t.Fatal("REVOKE removed a link of another note")
+	}
+	if revoked, err := sh.RevokeShare(ctx, noteID, other); err != nil || !revoked {
+		t.Fatal("REVOKE kept the link")
+	}
+	if links, err = sh.ListShares(ctx, noteID); err != nil || len(links) != 0 {
+		t.Fatal("LIST returned revoked or used up links")
+	}
+}
@@ -0,0 +1,341 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/gofrs/uuid"
+	_ "modernc.org/sqlite"
+	"time"
+)
+
+const sqliteNoteColumns = "id, owner_id, text, created, expiration, max_views, views_remaining, nonce, alg, key_id, data_key, pass_hash, failed_attempts"
+
+func sqliteVisible(now time.Time) sq.Sqlizer {
+	return sq.And{
+		sq.Expr("created + expiration * ? > ?", int64(time.Minute), now.UnixNano()),
+		sq.Or{sq.Eq{"views_remaining": nil}, sq.Gt{"views_remaining": 0}},
+		sq.Or{sq.Eq{"lease_until": nil}, sq.LtOrEq{"lease_until": now.UnixNano()}},
+	}
+}
+
+type SQLiteDB struct {
+	conn *sql.DB
+}
+
+func OpenSQLite(path string) (*sql.DB, error) {
+	// SQLite leaves foreign keys off unless asked per connection, without
+	// it the ON DELETE CASCADE clauses in the migrations do nothing.
+	conn, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite serializes writers anyway, a single connection keeps
+	// concurrent handlers from failing with SQLITE_BUSY.
+	conn.SetMaxOpenConns(1)
+
+	if err = conn.Ping(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func NewSQLiteDB(conn *sql.DB) NoteHandler {
+	return &SQLiteDB{conn: conn}
+}
+
+func (sdb *SQLiteDB) Create(ctx context.Context, n *Note) (*Note, error) {
+	note := newNote(n)
+	query, args, err := sq.Insert("notes").
+		SetMap(map[string]interface{}{
+			"id":              note.ID,
+			"owner_id":        note.OwnerID,
+			"text":            note.Text,
+			"created":         note.Created.UnixNano(),
+			"expiration":      note.Expiration,
+			"max_views":       note.MaxViews,
+			"views_remaining": note.ViewsRemaining,
+			"nonce":           note.Nonce,
+			"alg":             note.Algorithm,
+			"key_id":          note.KeyID,
+			"data_key":        note.DataKey,
+			"pass_hash":       note.PassHash,
+		}).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = sdb.conn.ExecContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return note, nil
+}
+
+func (sdb *SQLiteDB) Get(ctx context.Context, uid uuid.UUID) (*Note, error) {
+	query, args, err := sq.Select(sqliteNoteColumns).From("notes").
+		Where(sq.Eq{"id": uid}).Where(sqliteVisible(time.Now())).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := scanSQLiteNote(sdb.conn.QueryRowContext(ctx, query, args...))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return n, nil
+}
+
+func (sdb *SQLiteDB) View(ctx context.Context, uid uuid.UUID) (*Note, error) {
+	query, args, err := sq.Update("notes").Set("views_remaining", sq.Expr("views_remaining - 1")).
+		Where(sq.Eq{"id": uid}).Where(sqliteVisible(time.Now())).
+		Suffix("RETURNING " + sqliteNoteColumns).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := scanSQLiteNote(sdb.conn.QueryRowContext(ctx, query, args...))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if n.Exhausted() {
+		query, args, err = sq.Delete("notes").Where(sq.Eq{"id": uid, "views_remaining": 0}).ToSql()
+		if err != nil {
+			return nil, err
+		}
+		if _, err = sdb.conn.ExecContext(ctx, query, args...); err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+func (sdb *SQLiteDB) FailAttempt(ctx context.Context, uid uuid.UUID, maxAttempts int) (bool, error) {
+	query, args, err := sq.Update("notes").Set("failed_attempts", sq.Expr("failed_attempts + 1")).
+		Where(sq.Eq{"id": uid}).Where(sqliteVisible(time.Now())).
+		Suffix("RETURNING failed_attempts").ToSql()
+	if err != nil {
+		return false, err
+	}
+
+	var attempts int
+	if err = sdb.conn.QueryRowContext(ctx, query, args...).Scan(&attempts); err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	if attempts < maxAttempts {
+		return false, nil
+	}
+
+	query, args, err = sq.Delete("notes").Where(sq.Eq{"id": uid}).ToSql()
+	if err != nil {
+		return false, err
+	}
+	if _, err = sdb.conn.ExecContext(ctx, query, args...); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (sdb *SQLiteDB) List(ctx context.Context, f ListFilter) ([]*Note, string, error) {
+	c, err := f.cursor()
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	q := sq.Select(sqliteNoteColumns).From("notes").Where(sqliteVisible(now)).
+		OrderBy("created", "id").Limit(uint64(f.PageSize() + 1))
+	if !f.AllOwners {
+		q = q.Where(sq.Eq{"owner_id": f.Owner})
+	}
+	if c != nil {
+		q = q.Where("(created, id) > (?, ?)", c.created.UnixNano(), c.id)
+	}
+	if !f.CreatedBefore.IsZero() {
+		q = q.Where(sq.Lt{"created": f.CreatedBefore.UnixNano()})
+	}
+	if !f.CreatedAfter.IsZero() {
+		q = q.Where(sq.Gt{"created": f.CreatedAfter.UnixNano()})
+	}
+	if f.ExpiringWithin > 0 {
+		q = q.Where("created + expiration * ? <= ?", int64(time.Minute), now.Add(f.ExpiringWithin).UnixNano())
+	}
+	query, args, err := q.ToSql()
+	if err != nil {
+		return nil, "", err
+	}
+
+	var notes []*Note
+	rows, err := sdb.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		n, err := scanSQLiteNote(rows)
+		if err != nil {
+			return nil, "", err
+		}
+		notes = append(notes, n)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	notes, next := f.page(notes)
+	return notes, next, nil
+}
+
+func (sdb *SQLiteDB) Update(ctx context.Context, owner uuid.UUID, uid uuid.UUID, content *Note) (*Note, error) {
+	query, args, err := sq.Update("notes").
+		Set("text", content.Text).Set("nonce", content.Nonce).Set("alg", content.Algorithm).
+		Set("key_id", content.KeyID).Set("data_key", content.DataKey).
+		Where(sq.Eq{"id": uid, "owner_id": owner}).Where(sqliteVisible(time.Now())).
+		Suffix("RETURNING " + sqliteNoteColumns).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := scanSQLiteNote(sdb.conn.QueryRowContext(ctx, query, args...))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return n, nil
+}
+
+func (sdb *SQLiteDB) Swap(ctx context.Context, uid uuid.UUID, old *Note, content *Note) (bool, error) {
+	query, args, err := sq.Update("notes").
+		Set("text", content.Text).Set("nonce", content.Nonce).Set("alg", content.Algorithm).
+		Set("key_id", content.KeyID).Set("data_key", content.DataKey).
+		Where(sq.Eq{"id": uid, "text": old.Text, "key_id": old.KeyID, "data_key": old.DataKey}).
+		Where(sqliteVisible(time.Now())).ToSql()
+	if err != nil {
+		return false, err
+	}
+
+	res, err := sdb.conn.ExecContext(ctx, query, args...)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+func (sdb *SQLiteDB) Delete(ctx context.Context, owner uuid.UUID, uid uuid.UUID) (*Note, error) {
+	return sdb.delete(ctx, sq.Eq{"id": uid, "owner_id": owner})
+}
+
+func (sdb *SQLiteDB) Purge(ctx context.Context, uid uuid.UUID) (*Note, error) {
+	return sdb.delete(ctx, sq.Eq{"id": uid})
+}
+
+func (sdb *SQLiteDB) delete(ctx context.Context, where sq.Eq) (*Note, error) {
+	query, args, err := sq.Delete("notes").Where(where).
+		Suffix("RETURNING " + sqliteNoteColumns).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := scanSQLiteNote(sdb.conn.QueryRowContext(ctx, query, args...))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if !n.visible(time.Now()) {
+		return nil, nil
+	}
+	return n, nil
+}
+
+func (sdb *SQLiteDB) Reserve(ctx context.Context, uid uuid.UUID, lease time.Duration) (*Note, uuid.UUID, error) {
+	token, err := uuid.NewV4()
+	if err != nil {
+		return nil, uuid.Nil, err
+	}
+
+	now := time.Now()
+	query, args, err := sq.Update("notes").Set("lease_token", token).Set("lease_until", now.Add(lease).UnixNano()).
+		Where(sq.Eq{"id": uid}).Where(sqliteVisible(now)).
+		Suffix("RETURNING " + sqliteNoteColumns).ToSql()
+	if err != nil {
+		return nil, uuid.Nil, err
+	}
+
+	n, err := scanSQLiteNote(sdb.conn.QueryRowContext(ctx, query, args...))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, uuid.Nil, nil
+		}
+		return nil, uuid.Nil, err
+	}
+	return n, token, nil
+}
+
+func (sdb *SQLiteDB) Ack(ctx context.Context, uid uuid.UUID, token uuid.UUID) (bool, error) {
+	query, args, err := sq.Delete("notes").
+		Where(sq.Eq{"id": uid, "lease_token": token}).Where(sq.Gt{"lease_until": time.Now().UnixNano()}).ToSql()
+	if err != nil {
+		return false, err
+	}
+
+	res, err := sdb.conn.ExecContext(ctx, query, args...)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+func (sdb *SQLiteDB) ClearExpired(ctx context.Context) error {
+	query, args, err := sq.Delete("notes").
+		Where("created + expiration * ? < ?", int64(time.Minute), time.Now().UnixNano()).ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = sdb.conn.ExecContext(ctx, query, args...)
+	return err
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSQLiteNote(row rowScanner) (*Note, error) {
+	n := &Note{}
+	var created int64
+	var views sql.NullInt64
+	if err := row.Scan(&n.ID, &n.OwnerID, &n.Text, &created, &n.Expiration, &n.MaxViews, &views, &n.Nonce, &n.Algorithm, &n.KeyID, &n.DataKey, &n.PassHash, &n.FailedAttempts); err != nil {
+		return nil, err
+	}
+	n.Created = time.Unix(0, created)
+	if views.Valid {
+		remaining := int(views.Int64)
+		n.ViewsRemaining = &remaining
+	}
+	return n, nil
+}
@@ -0,0 +1,138 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/gofrs/uuid"
+	"time"
+)
+
+type SQLiteShareDB struct {
+	conn *sql.DB
+}
+
+func NewSQLiteShareDB(conn *sql.DB) ShareHandler {
+	return &SQLiteShareDB{conn: conn}
+}
+
+func scanSQLiteShare(row rowScanner) (*ShareLink, error) {
+	l := &ShareLink{}
+	var created, expiresAt int64
+	var views sql.NullInt64
+	if err := row.Scan(&l.ID, &l.NoteID, &l.Permission, &created, &expiresAt, &l.MaxViews, &views, &l.WrappedKey); err != nil {
+		return nil, err
+	}
+	l.Created = time.Unix(0, created)
+	l.ExpiresAt = time.Unix(0, expiresAt)
+	if views.Valid {
+		remaining := int(views.Int64)
+		l.ViewsRemaining = &remaining
+	}
+	return l, nil
+}
+
+func (sdb *SQLiteShareDB) CreateShare(ctx context.Context, l *ShareLink) (*ShareLink, error) {
+	link := newShareLink(l)
+	query, args, err := sq.Delete("share_links").Where(sq.LtOrEq{"expires_at": link.Created.UnixNano()}).ToSql()
+	if err != nil {
+		return nil, err
+	}
+	if _, err = sdb.conn.ExecContext(ctx, query, args...); err != nil {
+		return nil, err
+	}
+
+	query, args, err = sq.Insert("share_links").
+		SetMap(map[string]interface{}{
+			"id":              link.ID,
+			"note_id":         link.NoteID,
+			"permission":      link.Permission,
+			"created":         link.Created.UnixNano(),
+			"expires_at":      link.ExpiresAt.UnixNano(),
+			"max_views":       link.MaxViews,
+			"views_remaining": link.ViewsRemaining,
+			"wrapped_key":     link.WrappedKey,
+		}).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = sdb.conn.ExecContext(ctx, query, args...); err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+func (sdb *SQLiteShareDB) GetShare(ctx context.Context, id uuid.UUID) (*ShareLink, error) {
+	query, args, err := sq.Select(shareColumns).From("share_links").
+		Where(sq.Eq{"id": id}).Where(shareUsable(time.Now().UnixNano())).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := scanSQLiteShare(sdb.conn.QueryRowContext(ctx, query, args...))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return l, nil
+}
+
+func (sdb *SQLiteShareDB) ListShares(ctx context.Context, noteID uuid.UUID) ([]*ShareLink, error) {
+	query, args, err := sq.Select(shareColumns).From("share_links").
+		Where(sq.Eq{"note_id": noteID}).Where(shareUsable(time.Now().UnixNano())).
+		OrderBy("created").ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := sdb.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []*ShareLink
+	for rows.Next() {
+		l, err := scanSQLiteShare(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, l)
+	}
+	return links, rows.Err()
+}
+
+func (sdb *SQLiteShareDB) UseShare(ctx context.Context, id uuid.UUID) (*ShareLink, error) {
+	query, args, err := sq.Update("share_links").Set("views_remaining", sq.Expr("views_remaining - 1")).
+		Where(sq.Eq{"id": id}).Where(shareUsable(time.Now().UnixNano())).
+		Suffix("RETURNING " + shareColumns).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := scanSQLiteShare(sdb.conn.QueryRowContext(ctx, query, args...))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return l, nil
+}
+
+func (sdb *SQLiteShareDB) RevokeShare(ctx context.Context, noteID, id uuid.UUID) (bool, error) {
+	query, args, err := sq.Delete("share_links").Where(sq.Eq{"id": id, "note_id": noteID}).ToSql()
+	if err != nil {
+		return false, err
+	}
+
+	res, err := sdb.conn.ExecContext(ctx, query, args...)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
@@ -0,0 +1,78 @@
+package db_test
+
+import (
+	"context"
+	"github.com/gofrs/uuid"
+	"github.com/pimka/go-onenote/db"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSQLiteDB(t *testing.T) {
+	conf := db.Config{
+		Driver:     db.DriverSQLite,
+		SQLitePath: filepath.Join(t.TempDir(), "notes.db"),
+	}
+	if err := db.Migrate(conf); err != nil {
+		t.Fatal(err)
+	}
+
+	storage, err := db.Open(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	nh := storage.Notes
+
+	testNoteHandler(t, nh)
+	testNoteHandlerPages(t, nh)
+	testNoteHandlerViews(t, nh)
+	testNoteHandlerAttempts(t, nh)
+	testNoteHandlerOwners(t, nh)
+	testNoteHandlerSwap(t, nh)
+	testNoteHandlerLeases(t, nh)
+	testUserHandler(t, storage.Users)
+	testTokenHandler(t, storage.Tokens, storage.Users)
+	testShareHandler(t, storage.Shares, storage.Notes)
+}
+
+func TestSQLiteDB_Cascade(t *testing.T) {
+	conf := db.Config{
+		Driver:     db.DriverSQLite,
+		SQLitePath: filepath.Join(t.TempDir(), "notes.db"),
+	}
+	if err := db.Migrate(conf); err != nil {
+		t.Fatal(err)
+	}
+
+	storage, err := db.Open(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	ctx := context.Background()
+
+	noteID, _ := uuid.NewV4()
+	if _, err = storage.Notes.Create(ctx, &db.Note{ID: noteID, Text: "shared", Expiration: 10}); err != nil {
+		t.Fatal(err)
+	}
+	id, _ := uuid.NewV4()
+	link := &db.ShareLink{ID: id, NoteID: noteID, Permission: db.SharePermRead, ExpiresAt: time.Now().Add(time.Hour)}
+	if _, err = storage.Shares.CreateShare(ctx, link); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = storage.Notes.Delete(ctx, uuid.Nil, noteID); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := storage.Shares.GetShare(ctx, id); err != nil || got != nil {
+		t.Errorf("share link outlived its note: %v, %v", got, err)
+	}
+
+	missing, _ := uuid.NewV4()
+	orphan := &db.ShareLink{ID: missing, NoteID: missing, Permission: db.SharePermRead, ExpiresAt: time.Now().Add(time.Hour)}
+	if _, err = storage.Shares.CreateShare(ctx, orphan); err == nil {
+		t.Error("CREATE accepted a link to a missing note")
+	}
+}
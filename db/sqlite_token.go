@@ -0,0 +1,131 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/gofrs/uuid"
+	"time"
+)
+
+type SQLiteTokenDB struct {
+	conn *sql.DB
+}
+
+func NewSQLiteTokenDB(conn *sql.DB) TokenHandler {
+	return &SQLiteTokenDB{conn: conn}
+}
+
+func sqliteTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UnixNano()
+}
+
+func scanSQLiteToken(row rowScanner) (*AccessToken, error) {
+	t := &AccessToken{}
+	var scopes string
+	var created int64
+	var expiresAt, lastUsed sql.NullInt64
+	if err := row.Scan(&t.ID, &t.UserID, &t.Name, &t.Hash, &scopes, &created, &expiresAt, &lastUsed); err != nil {
+		return nil, err
+	}
+	t.Scopes = splitScopes(scopes)
+	t.Created = time.Unix(0, created)
+	if expiresAt.Valid {
+		at := time.Unix(0, expiresAt.Int64)
+		t.ExpiresAt = &at
+	}
+	if lastUsed.Valid {
+		at := time.Unix(0, lastUsed.Int64)
+		t.LastUsed = &at
+	}
+	return t, nil
+}
+
+func (sdb *SQLiteTokenDB) CreateToken(ctx context.Context, t *AccessToken) (*AccessToken, error) {
+	token := newAccessToken(t)
+	query, args, err := sq.Insert("access_tokens").
+		SetMap(map[string]interface{}{
+			"id":         token.ID,
+			"user_id":    token.UserID,
+			"name":       token.Name,
+			"hash":       token.Hash,
+			"scopes":     joinScopes(token.Scopes),
+			"created":    token.Created.UnixNano(),
+			"expires_at": sqliteTime(token.ExpiresAt),
+		}).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = sdb.conn.ExecContext(ctx, query, args...); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func (sdb *SQLiteTokenDB) GetTokenByHash(ctx context.Context, hash string) (*AccessToken, error) {
+	query, args, err := sq.Select(tokenColumns).From("access_tokens").Where(sq.Eq{"hash": hash}).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := scanSQLiteToken(sdb.conn.QueryRowContext(ctx, query, args...))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return t, nil
+}
+
+func (sdb *SQLiteTokenDB) ListTokens(ctx context.Context, userID uuid.UUID) ([]*AccessToken, error) {
+	query, args, err := sq.Select(tokenColumns).From("access_tokens").Where(sq.Eq{"user_id": userID}).
+		OrderBy("created").ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := sdb.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []*AccessToken
+	for rows.Next() {
+		t, err := scanSQLiteToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+func (sdb *SQLiteTokenDB) RevokeToken(ctx context.Context, userID, id uuid.UUID) (bool, error) {
+	query, args, err := sq.Delete("access_tokens").Where(sq.Eq{"id": id, "user_id": userID}).ToSql()
+	if err != nil {
+		return false, err
+	}
+
+	res, err := sdb.conn.ExecContext(ctx, query, args...)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (sdb *SQLiteTokenDB) TouchToken(ctx context.Context, id uuid.UUID, at time.Time) error {
+	query, args, err := sq.Update("access_tokens").Set("last_used", at.UnixNano()).Where(sq.Eq{"id": id}).ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = sdb.conn.ExecContext(ctx, query, args...)
+	return err
+}
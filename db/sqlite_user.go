@@ -0,0 +1,85 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/gofrs/uuid"
+	"strings"
+	"time"
+)
+
+type SQLiteUserDB struct {
+	conn *sql.DB
+}
+
+func NewSQLiteUserDB(conn *sql.DB) UserHandler {
+	return &SQLiteUserDB{conn: conn}
+}
+
+func (sdb *SQLiteUserDB) CreateUser(ctx context.Context, u *User) (*User, error) {
+	user := newUser(u)
+	query, args, err := sq.Insert("users").
+		SetMap(map[string]interface{}{
+			"id":        user.ID,
+			"username":  user.Username,
+			"pass_hash": user.PassHash,
+			"created":   user.Created.UnixNano(),
+			"role":      user.Role,
+		}).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = sdb.conn.ExecContext(ctx, query, args...); err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return nil, ErrUserExists
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+func (sdb *SQLiteUserDB) getUser(ctx context.Context, where sq.Eq) (*User, error) {
+	query, args, err := sq.Select(userColumns).From("users").Where(where).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	u := &User{}
+	var created int64
+	err = sdb.conn.QueryRowContext(ctx, query, args...).Scan(&u.ID, &u.Username, &u.PassHash, &created, &u.Role)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	u.Created = time.Unix(0, created)
+	return u, nil
+}
+
+func (sdb *SQLiteUserDB) GetUser(ctx context.Context, uid uuid.UUID) (*User, error) {
+	return sdb.getUser(ctx, sq.Eq{"id": uid})
+}
+
+func (sdb *SQLiteUserDB) GetUserByName(ctx context.Context, username string) (*User, error) {
+	return sdb.getUser(ctx, sq.Eq{"username": username})
+}
+
+func (sdb *SQLiteUserDB) SetRole(ctx context.Context, uid uuid.UUID, role string) (bool, error) {
+	query, args, err := sq.Update("users").Set("role", role).Where(sq.Eq{"id": uid}).ToSql()
+	if err != nil {
+		return false, err
+	}
+
+	res, err := sdb.conn.ExecContext(ctx, query, args...)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
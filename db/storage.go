@@ -0,0 +1,92 @@
+package db
+
+import (
+	"fmt"
+)
+
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+	DriverMemory   = "memory"
+	DriverRedis    = "redis"
+	DriverBolt     = "bolt"
+)
+
+// Storage bundles the stores of one backend, they share its connections.
+type Storage struct {
+	Notes  NoteHandler
+	Users  UserHandler
+	Tokens TokenHandler
+	Shares ShareHandler
+	close  func()
+}
+
+// Close releases the underlying connections.
+func (s *Storage) Close() {
+	s.close()
+}
+
+// Open connects to the backend selected by c.Driver.
+func Open(c Config) (*Storage, error) {
+	switch c.Driver {
+	case DriverPostgres:
+		database, err := Connect(c)
+		if err != nil {
+			return nil, err
+		}
+		return &Storage{
+			Notes:  NewNoteDB(database.Pool),
+			Users:  NewUserDB(database.Pool),
+			Tokens: NewTokenDB(database.Pool),
+			Shares: NewShareDB(database.Pool),
+			close:  database.Close,
+		}, nil
+	case DriverSQLite:
+		conn, err := OpenSQLite(c.SQLitePath)
+		if err != nil {
+			return nil, err
+		}
+		return &Storage{
+			Notes:  NewSQLiteDB(conn),
+			Users:  NewSQLiteUserDB(conn),
+			Tokens: NewSQLiteTokenDB(conn),
+			Shares: NewSQLiteShareDB(conn),
+			close:  func() { conn.Close() },
+		}, nil
+	case DriverMemory:
+		mdb := NewMemoryDB()
+		return &Storage{
+			Notes:  mdb,
+			Users:  NewMemoryUserDB(),
+			Tokens: NewMemoryTokenDB(),
+			Shares: NewMemoryShareDB(),
+			close:  mdb.Close,
+		}, nil
+	case DriverRedis:
+		client, err := OpenRedis(c.RedisURL)
+		if err != nil {
+			return nil, err
+		}
+		return &Storage{
+			Notes:  NewRedisDB(client),
+			Users:  NewRedisUserDB(client),
+			Tokens: NewRedisTokenDB(client),
+			Shares: NewRedisShareDB(client),
+			close:  func() { client.Close() },
+		}, nil
+	case DriverBolt:
+		bdb, err := OpenBolt(c.BoltPath)
+		if err != nil {
+			return nil, err
+		}
+		return &Storage{
+			Notes:  NewBoltDB(bdb),
+			Users:  NewBoltUserDB(bdb),
+			Tokens: NewBoltTokenDB(bdb),
+			Shares: NewBoltShareDB(bdb),
+			close:  func() { bdb.Close() },
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", c.Driver)
+	}
+}
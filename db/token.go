@@ -0,0 +1,185 @@
+package db
+
+import (
+	"context"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AccessToken is a personal access token of a user. Only the SHA-256 of the
+// token is stored, the token itself is shown once when it is created.
+type AccessToken struct {
+	ID        uuid.UUID  `json:"id"`
+	UserID    uuid.UUID  `json:"user_id"`
+	Name      string     `json:"name"`
+	Hash      string     `json:"hash,omitempty"`
+	Scopes    []string   `json:"scopes"`
+	Created   time.Time  `json:"created"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	LastUsed  *time.Time `json:"last_used,omitempty"`
+}
+
+func (t *AccessToken) Expired(now time.Time) bool {
+	return t.ExpiresAt != nil && !t.ExpiresAt.After(now)
+}
+
+type TokenHandler interface {
+	CreateToken(ctx context.Context, t *AccessToken) (*AccessToken, error)
+	GetTokenByHash(ctx context.Context, hash string) (*AccessToken, error)
+	ListTokens(ctx context.Context, userID uuid.UUID) ([]*AccessToken, error)
+	// RevokeToken deletes a token of the user, it reports whether there was
+	// one.
+	RevokeToken(ctx context.Context, userID, id uuid.UUID) (bool, error)
+	// TouchToken records that the token was used at.
+	TouchToken(ctx context.Context, id uuid.UUID, at time.Time) error
+}
+
+func newAccessToken(t *AccessToken) *AccessToken {
+	return &AccessToken{
+		ID:        t.ID,
+		UserID:    t.UserID,
+		Name:      t.Name,
+		Hash:      t.Hash,
+		Scopes:    append([]string(nil), t.Scopes...),
+		Created:   time.Now(),
+		ExpiresAt: t.ExpiresAt,
+	}
+}
+
+func (t *AccessToken) clone() *AccessToken {
+	c := *t
+	c.Scopes = append([]string(nil), t.Scopes...)
+	if t.LastUsed != nil {
+		at := *t.LastUsed
+		c.LastUsed = &at
+	}
+	return &c
+}
+
+func sortTokens(tokens []*AccessToken) {
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].Created.Before(tokens[j].Created)
+	})
+}
+
+// Scopes are kept space separated, the way OAuth writes them.
+func joinScopes(scopes []string) string {
+	return strings.Join(scopes, " ")
+}
+
+func splitScopes(scopes string) []string {
+	return strings.Fields(scopes)
+}
+
+const tokenColumns = "id, user_id, name, hash, scopes, created, expires_at, last_used"
+
+type TokenDB struct {
+	pool *pgxpool.Pool
+}
+
+func NewTokenDB(pool *pgxpool.Pool) TokenHandler {
+	return &TokenDB{pool: pool}
+}
+
+func scanToken(row pgx.Row) (*AccessToken, error) {
+	t := &AccessToken{}
+	var scopes string
+	if err := row.Scan(&t.ID, &t.UserID, &t.Name, &t.Hash, &scopes, &t.Created, &t.ExpiresAt, &t.LastUsed); err != nil {
+		return nil, err
+	}
+	t.Scopes = splitScopes(scopes)
+	return t, nil
+}
+
+func (tdb *TokenDB) CreateToken(ctx context.Context, t *AccessToken) (*AccessToken, error) {
+	token := newAccessToken(t)
+	query, args, err := sq.Insert("access_tokens").
+		SetMap(map[string]interface{}{
+			"id":         token.ID,
+			"user_id":    token.UserID,
+			"name":       token.Name,
+			"hash":       token.Hash,
+			"scopes":     joinScopes(token.Scopes),
+			"created":    token.Created,
+			"expires_at": token.ExpiresAt,
+		}).PlaceholderFormat(sq.Dollar).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = tdb.pool.Exec(ctx, query, args...); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func (tdb *TokenDB) GetTokenByHash(ctx context.Context, hash string) (*AccessToken, error) {
+	query, args, err := sq.Select(tokenColumns).From("access_tokens").Where(sq.Eq{"hash": hash}).
+		PlaceholderFormat(sq.Dollar).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := scanToken(tdb.pool.QueryRow(ctx, query, args...))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return t, nil
+}
+
+func (tdb *TokenDB) ListTokens(ctx context.Context, userID uuid.UUID) ([]*AccessToken, error) {
+	query, args, err := sq.Select(tokenColumns).From("access_tokens").Where(sq.Eq{"user_id": userID}).
+		OrderBy("created").PlaceholderFormat(sq.Dollar).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tdb.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []*AccessToken
+	for rows.Next() {
+		t, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+func (tdb *TokenDB) RevokeToken(ctx context.Context, userID, id uuid.UUID) (bool, error) {
+	query, args, err := sq.Delete("access_tokens").Where(sq.Eq{"id": id, "user_id": userID}).
+		PlaceholderFormat(sq.Dollar).ToSql()
+	if err != nil {
+		return false, err
+	}
+
+	tag, err := tdb.pool.Exec(ctx, query, args...)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (tdb *TokenDB) TouchToken(ctx context.Context, id uuid.UUID, at time.Time) error {
+	query, args, err := sq.Update("access_tokens").Set("last_used", at).Where(sq.Eq{"id": id}).
+		PlaceholderFormat(sq.Dollar).ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = tdb.pool.Exec(ctx, query, args...)
+	return err
+}
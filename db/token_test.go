@@ -0,0 +1,84 @@
+package db_test
+
+import (
+	"context"
+	"github.com/gofrs/uuid"
+	"github.com/pimka/go-onenote/db"
+	"testing"
+	"time"
+)
+
+func testTokenHandler(t *testing.T, th db.TokenHandler, uh db.UserHandler) {
+	ctx := context.Background()
+
+	userID, _ := uuid.NewV4()
+	if _, err := uh.CreateUser(ctx, &db.User{ID: userID, Username: "token-owner", PassHash: "$argon2id$hash"}); err != nil {
+		t.Fatal(err)
+	}
+
+	id, _ := uuid.NewV4()
+	expires := time.Now().Add(time.Hour).Truncate(time.Microsecond)
+	token, err := th.CreateToken(ctx, &db.AccessToken{
+		ID:        id,
+		UserID:    userID,
+		Name:      "ci",
+		Hash:      "hash-1",
+		Scopes:    []string{"notes:read", "notes:write"},
+		ExpiresAt: &expires,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.ID != id || token.Created.IsZero() || token.LastUsed != nil {
+		t.Fatal("CREATE returned another token")
+	}
+	other, _ := uuid.NewV4()
+	if _, err = th.CreateToken(ctx, &db.AccessToken{ID: other, UserID: userID, Name: "cron", Hash: "hash-2", Scopes: []string{"admin"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := th.GetTokenByHash(ctx, "hash-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.ID != id || got.UserID != userID || len(got.Scopes) != 2 || got.Scopes[1] != "notes:write" {
+		t.Fatal("GET returned another token")
+	}
+	if got.ExpiresAt == nil || !got.ExpiresAt.Equal(expires) || got.Expired(time.Now()) {
+		t.Fatal("GET lost the expiry")
+	}
+	if got, err = th.GetTokenByHash(ctx, "hash-3"); err != nil || got != nil {
+		t.Fatal("GET returned a token that doesn't exist")
+	}
+
+	used := time.Now().Truncate(time.Microsecond)
+	if err = th.TouchToken(ctx, id, used); err != nil {
+		t.Fatal(err)
+	}
+	got, _ = th.GetTokenByHash(ctx, "hash-1")
+	if got.LastUsed == nil || !got.LastUsed.Equal(used) {
+		t.Fatal("TOUCH didn't record the last use")
+	}
+
+	tokens, err := th.ListTokens(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 2 || tokens[0].ID != id || tokens[1].ID != other {
+		t.Fatal("LIST returned other tokens")
+	}
+
+	stranger, _ := uuid.NewV4()
+	if revoked, err := th.RevokeToken(ctx, stranger, id); err != nil || revoked {
+		t.Fatal("REVOKE revoked another user's token")
+	}
+	if revoked, err := th.RevokeToken(ctx, userID, id); err != nil || !revoked {
+		t.Fatal("REVOKE kept the token")
+	}
+	if got, _ = th.GetTokenByHash(ctx, "hash-1"); got != nil {
+		t.Fatal("GET returned a revoked token")
+	}
+	if tokens, _ = th.ListTokens(ctx, userID); len(tokens) != 1 {
+		t.Fatal("LIST returned a revoked token")
+	}
+}
@@ -0,0 +1,127 @@
+package db
+
+import (
+	"context"
+	"errors"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"time"
+)
+
+type User struct {
+	ID       uuid.UUID `json:"id"`
+	Username string    `json:"username"`
+	// PassHash is the Argon2id hash of the password, it never leaves the
+	// server.
+	PassHash string    `json:"pass_hash,omitempty"`
+	Created  time.Time `json:"created"`
+	// Role is one of the auth roles, empty for users created before roles
+	// existed.
+	Role string `json:"role,omitempty"`
+}
+
+var ErrUserExists = errors.New("username is taken")
+
+type UserHandler interface {
+	// CreateUser stores a new user, it returns ErrUserExists when the
+	// username is taken.
+	CreateUser(ctx context.Context, u *User) (*User, error)
+	GetUser(ctx context.Context, uid uuid.UUID) (*User, error)
+	GetUserByName(ctx context.Context, username string) (*User, error)
+	// SetRole changes the role of the user and reports whether it exists.
+	SetRole(ctx context.Context, uid uuid.UUID, role string) (bool, error)
+}
+
+func newUser(u *User) *User {
+	return &User{
+		ID:       u.ID,
+		Username: u.Username,
+		PassHash: u.PassHash,
+		Created:  time.Now(),
+		Role:     u.Role,
+	}
+}
+
+const userColumns = "id, username, pass_hash, created, role"
+
+type UserDB struct {
+	pool *pgxpool.Pool
+}
+
+func NewUserDB(pool *pgxpool.Pool) UserHandler {
+	return &UserDB{pool: pool}
+}
+
+func scanUser(row pgx.Row) (*User, error) {
+	u := &User{}
+	if err := row.Scan(&u.ID, &u.Username, &u.PassHash, &u.Created, &u.Role); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func (udb *UserDB) CreateUser(ctx context.Context, u *User) (*User, error) {
+	user := newUser(u)
+	query, args, err := sq.Insert("users").
+		SetMap(map[string]interface{}{
+			"id":        user.ID,
+			"username":  user.Username,
+			"pass_hash": user.PassHash,
+			"created":   user.Created,
+			"role":      user.Role,
+		}).PlaceholderFormat(sq.Dollar).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = udb.pool.Exec(ctx, query, args...); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return nil, ErrUserExists
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+func (udb *UserDB) getUser(ctx context.Context, where sq.Eq) (*User, error) {
+	query, args, err := sq.Select(userColumns).From("users").Where(where).
+		PlaceholderFormat(sq.Dollar).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := scanUser(udb.pool.QueryRow(ctx, query, args...))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return u, nil
+}
+
+func (udb *UserDB) GetUser(ctx context.Context, uid uuid.UUID) (*User, error) {
+	return udb.getUser(ctx, sq.Eq{"id": uid})
+}
+
+func (udb *UserDB) GetUserByName(ctx context.Context, username string) (*User, error) {
+	return udb.getUser(ctx, sq.Eq{"username": username})
+}
+
+func (udb *UserDB) SetRole(ctx context.Context, uid uuid.UUID, role string) (bool, error) {
+	query, args, err := sq.Update("users").Set("role", role).Where(sq.Eq{"id": uid}).
+		PlaceholderFormat(sq.Dollar).ToSql()
+	if err != nil {
+		return false, err
+	}
+
+	tag, err := udb.pool.Exec(ctx, query, args...)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
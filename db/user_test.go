@@ -0,0 +1,58 @@
+package db_test
+
+import (
+	"context"
+	"github.com/gofrs/uuid"
+	"github.com/pimka/go-onenote/db"
+	"testing"
+)
+
+func testUserHandler(t *testing.T, uh db.UserHandler) {
+	ctx := context.Background()
+
+	uid, _ := uuid.NewV4()
+	user, err := uh.CreateUser(ctx, &db.User{ID: uid, Username: "pupa", PassHash: "$argon2id$hash", Role: "editor"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != uid || user.Username != "pupa" || user.Created.IsZero() || user.Role != "editor" {
+		t.Fatal("CREATE returned another user")
+	}
+
+	other, _ := uuid.NewV4()
+	if _, err = uh.CreateUser(ctx, &db.User{ID: other, Username: "pupa", PassHash: "$argon2id$other"}); err != db.ErrUserExists {
+		t.Fatal("CREATE accepted a taken username")
+	}
+
+	u, err := uh.GetUser(ctx, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u == nil || u.Username != "pupa" || u.PassHash != "$argon2id$hash" {
+		t.Fatal("GET returned another user")
+	}
+	u, err = uh.GetUserByName(ctx, "pupa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u == nil || u.ID != uid {
+		t.Fatal("GET by name returned another user")
+	}
+
+	if u, err = uh.GetUser(ctx, other); err != nil || u != nil {
+		t.Fatal("GET returned a user that doesn't exist")
+	}
+	if u, err = uh.GetUserByName(ctx, "lupa"); err != nil || u != nil {
+		t.Fatal("GET by name returned a user that doesn't exist")
+	}
+
+	if found, err := uh.SetRole(ctx, uid, "admin"); err != nil || !found {
+		t.Fatal("SET ROLE didn't find the user")
+	}
+	if u, err = uh.GetUserByName(ctx, "pupa"); err != nil || u.Role != "admin" || u.PassHash != "$argon2id$hash" {
+		t.Fatal("SET ROLE didn't change the role")
+	}
+	if found, err := uh.SetRole(ctx, other, "admin"); err != nil || found {
+		t.Fatal("SET ROLE found a user that doesn't exist")
+	}
+}
@@ -0,0 +1,71 @@
+package secret
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"strings"
+)
+
+// Argon2id parameters, the second recommended option of RFC 9106.
+const (
+	argonTime    = 1
+	argonMemory  = 64 * 1024
+	argonThreads = 4
+	saltSize     = 16
+	hashSize     = 32
+)
+
+var ErrInvalidHash = errors.New("invalid argon2id hash")
+
+// HashPassphrase hashes pass with Argon2id under a random salt. One Argon2id
+// run yields 64 bytes: the first half is the verifier kept in the returned
+// PHC string, the second half is a key that is only ever recomputed from the
+// passphrase and can encrypt whatever the passphrase protects.
+func HashPassphrase(pass string) (string, []byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", nil, err
+	}
+	out := argon2.IDKey([]byte(pass), salt, argonTime, argonMemory, argonThreads, hashSize+KeySize)
+	encoded := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, argonMemory, argonTime, argonThreads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(out[:hashSize]))
+	return encoded, out[hashSize:], nil
+}
+
+// VerifyPassphrase checks pass against a hash from HashPassphrase and returns
+// the key derived along with it when they match.
+func VerifyPassphrase(encoded, pass string) ([]byte, bool, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, false, ErrInvalidHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, false, ErrInvalidHash
+	}
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return nil, false, ErrInvalidHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, false, ErrInvalidHash
+	}
+	hash, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return nil, false, ErrInvalidHash
+	}
+
+	out := argon2.IDKey([]byte(pass), salt, time, memory, threads, uint32(len(hash))+KeySize)
+	if subtle.ConstantTimeCompare(out[:len(hash)], hash) != 1 {
+		return nil, false, nil
+	}
+	return out[len(hash):], true, nil
+}
@@ -0,0 +1,67 @@
+package secret_test
+
+import (
+	"github.com/pimka/go-onenote/secret"
+	"testing"
+)
+
+func TestPassphrase(t *testing.T) {
+	hash, key, err := secret.HashPassphrase("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := secret.Seal(key, "battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	derived, ok, err := secret.VerifyPassphrase(hash, "correct horse")
+	if err != nil || !ok {
+		t.Fatal("VERIFY rejected the right passphrase")
+	}
+	text, err := secret.Open(derived, sealed)
+	if err != nil || text != "battery staple" {
+		t.Fatal("OPEN with the derived key failed")
+	}
+
+	if _, ok, err = secret.VerifyPassphrase(hash, "wrong horse"); err != nil || ok {
+		t.Fatal("VERIFY accepted a wrong passphrase")
+	}
+	if _, _, err = secret.VerifyPassphrase("plain", "correct horse"); err != secret.ErrInvalidHash {
+		t.Fatal("VERIFY accepted a broken hash")
+	}
+}
+
+func TestToken(t *testing.T) {
+	token, err := secret.NewToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := secret.NewToken()
+
+	hash, err := secret.HashToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := secret.HashToken(token); again != hash {
+		t.Fatal("HASH isn't stable")
+	}
+	if otherHash, _ := secret.HashToken(other); otherHash == hash {
+		t.Fatal("HASH collided")
+	}
+
+	key, err := secret.TokenKey(token, "note")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(key) == string(hash[:]) {
+		t.Fatal("KEY is the token hash")
+	}
+	if otherKey, _ := secret.TokenKey(token, "other"); string(otherKey) == string(key) {
+		t.Fatal("KEY ignored info")
+	}
+
+	if _, err = secret.HashToken("not-a-token"); err != secret.ErrInvalidToken {
+		t.Fatal("HASH accepted a broken token")
+	}
+}
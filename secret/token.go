@@ -0,0 +1,49 @@
+package secret
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"golang.org/x/crypto/hkdf"
+	"io"
+)
+
+const tokenSize = 32
+
+var ErrInvalidToken = errors.New("invalid token")
+
+// NewToken returns a random bearer token, base64url encoded so it fits in a
+// URL path.
+func NewToken() (string, error) {
+	raw := make([]byte, tokenSize)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// HashToken returns the SHA-256 of a token from NewToken, the only form of it
+// that may be stored.
+func HashToken(token string) ([sha256.Size]byte, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(raw) != tokenSize {
+		return [sha256.Size]byte{}, ErrInvalidToken
+	}
+	return sha256.Sum256(raw), nil
+}
+
+// TokenKey derives a key from a token with HKDF-SHA256, info keeps keys for
+// different purposes apart. It has no relation to HashToken, so knowing the
+// hash doesn't help to find the key.
+func TokenKey(token string, info string) ([]byte, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(raw) != tokenSize {
+		return nil, ErrInvalidToken
+	}
+	key := make([]byte, KeySize)
+	if _, err = io.ReadFull(hkdf.New(sha256.New, raw, nil, []byte(info)), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
@@ -0,0 +1,21 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/pimka/go-onenote/auth"
+	"log"
+)
+
+// audit logs a security relevant event with the client IP and the user it
+// came from, "-" for what isn't known.
+func audit(ctx context.Context, format string, args ...interface{}) {
+	ip, user := "-", "-"
+	if clientIP := ClientIPFromContext(ctx); clientIP != nil {
+		ip = clientIP.String()
+	}
+	if p := auth.FromContext(ctx); p != nil {
+		user = p.ID().String()
+	}
+	log.Printf("audit: %s ip=%s user=%s", fmt.Sprintf(format, args...), ip, user)
+}
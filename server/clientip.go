@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Forwarding headers TrustedProxies can read.
+const (
+	HeaderForwarded     = "Forwarded"
+	HeaderXForwardedFor = "X-Forwarded-For"
+	HeaderXRealIP       = "X-Real-IP"
+)
+
+// TrustedProxies resolves the client IP of requests that come through
+// proxies it trusts. Forwarding headers from anyone else are ignored, they
+// are trivial to forge.
+type TrustedProxies struct {
+	// header is the one the proxies write, the others pass through from
+	// the client as they are.
+	header string
+	nets   []*net.IPNet
+}
+
+// NewTrustedProxies takes the forwarding header the proxies in front of the
+// server write, X-Forwarded-For when empty, and their CIDRs or single IPs.
+func NewTrustedProxies(header string, cidrs []string) (*TrustedProxies, error) {
+	tp := &TrustedProxies{}
+	switch {
+	case strings.EqualFold(header, HeaderForwarded):
+		tp.header = HeaderForwarded
+	case strings.EqualFold(header, HeaderXForwardedFor), header == "":
+		tp.header = HeaderXForwardedFor
+	case strings.EqualFold(header, HeaderXRealIP):
+		tp.header = HeaderXRealIP
+	default:
+		return nil, fmt.Errorf("unknown forwarding header %q", header)
+	}
+	for _, c := range cidrs {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		if !strings.Contains(c, "/") {
+			ip := net.ParseIP(c)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", c)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			tp.nets = append(tp.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", c)
+		}
+		tp.nets = append(tp.nets, n)
+	}
+	return tp, nil
+}
+
+func (tp *TrustedProxies) trusted(ip net.IP) bool {
+	if tp == nil {
+		return false
+	}
+	for _, n := range tp.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the IP of the client behind request. When the peer is a
+// trusted proxy the hops of the header the proxies write are walked from
+// the right and the first untrusted one is the client, other forwarding
+// headers are ignored. Nil when RemoteAddr isn't an address.
+func (tp *TrustedProxies) ClientIP(request *http.Request) net.IP {
+	ip := parseHop(request.RemoteAddr)
+	if ip == nil || !tp.trusted(ip) {
+		return ip
+	}
+
+	var hops []string
+	if tp.header == HeaderForwarded {
+		hops = forwardedHops(request.Header.Values(tp.header))
+	} else {
+		hops = listHops(request.Header.Values(tp.header))
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := parseHop(hops[i])
+		if hop == nil {
+			// "unknown", an obfuscated node or garbage, nothing left of it
+			// can be trusted
+			break
+		}
+		ip = hop
+		if !tp.trusted(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// Middleware puts the client IP into the request context.
+func (tp *TrustedProxies) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if ip := tp.ClientIP(request); ip != nil {
+			request = request.WithContext(WithClientIP(request.Context(), ip))
+		}
+		next.ServeHTTP(writer, request)
+	})
+}
+
+// listHops splits comma separated header values, several header lines are
+// read in order.
+func listHops(values []string) []string {
+	var hops []string
+	for _, v := range values {
+		for _, hop := range strings.Split(v, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
+
+// forwardedHops reads the for= parameters of RFC 7239 Forwarded headers.
+// An element without one is kept as an empty hop so it stops the walk.
+func forwardedHops(values []string) []string {
+	var hops []string
+	for _, element := range listHops(values) {
+		hop := ""
+		for _, pair := range strings.Split(element, ";") {
+			name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if ok && strings.EqualFold(name, "for") {
+				hop = strings.Trim(value, `"`)
+			}
+		}
+		hops = append(hops, hop)
+	}
+	return hops
+}
+
+// parseHop reads an IP with an optional port, IPv6 in brackets when it has
+// one.
+func parseHop(hop string) net.IP {
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		hop = host
+	}
+	return net.ParseIP(strings.Trim(hop, "[]"))
+}
+
+type clientIPKey struct{}
+
+func WithClientIP(ctx context.Context, ip net.IP) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+// ClientIPFromContext returns the IP TrustedProxies resolved for the
+// request, nil when there is none.
+func ClientIPFromContext(ctx context.Context) net.IP {
+	ip, _ := ctx.Value(clientIPKey{}).(net.IP)
+	return ip
+}
@@ -0,0 +1,102 @@
+package server_test
+
+import (
+	"bytes"
+	"github.com/pimka/go-onenote/server"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestTrustedProxies_ClientIP(t *testing.T) {
+	cidrs := []string{"10.0.0.0/8", "2001:db8::/32", "192.0.2.1"}
+
+	for _, c := range []struct {
+		header  string
+		remote  string
+		headers map[string][]string
+		want    string
+	}{
+		{server.HeaderXForwardedFor, "203.0.113.7:1234", map[string][]string{"X-Forwarded-For": {"198.51.100.1"}}, "203.0.113.7"},
+		{server.HeaderXForwardedFor, "10.0.0.2:1234", nil, "10.0.0.2"},
+		{server.HeaderXForwardedFor, "10.0.0.2:1234", map[string][]string{"X-Forwarded-For": {"198.51.100.1, 203.0.113.9, 10.1.1.1"}}, "203.0.113.9"},
+		{server.HeaderXForwardedFor, "10.0.0.2:1234", map[string][]string{"X-Forwarded-For": {"198.51.100.1", "203.0.113.9"}}, "203.0.113.9"},
+		{server.HeaderXForwardedFor, "10.0.0.2:1234", map[string][]string{"X-Forwarded-For": {"10.2.2.2, 192.0.2.1"}}, "10.2.2.2"},
+		{server.HeaderXForwardedFor, "10.0.0.2:1234", map[string][]string{"X-Forwarded-For": {"unknown, 10.3.3.3"}}, "10.3.3.3"},
+		// a client's own Forwarded and X-Real-IP pass through the proxies
+		// that append to X-Forwarded-For
+		{server.HeaderXForwardedFor, "10.0.0.2:1234", map[string][]string{
+			"Forwarded":       {"for=198.51.100.66"},
+			"X-Real-IP":       {"198.51.100.67"},
+			"X-Forwarded-For": {"203.0.113.8"},
+		}, "203.0.113.8"},
+		{"", "10.0.0.2:1234", map[string][]string{"Forwarded": {"for=198.51.100.66"}}, "10.0.0.2"},
+		{server.HeaderXRealIP, "10.0.0.2:1234", map[string][]string{"X-Real-IP": {"198.51.100.5"}}, "198.51.100.5"},
+		{server.HeaderForwarded, "10.0.0.2:1234", map[string][]string{
+			"Forwarded":       {`for=198.51.100.1;proto=https, for="[2001:db8:cafe::17]:4711", for="203.0.113.4:80";by=10.0.0.2`},
+			"X-Forwarded-For": {"198.51.100.99"},
+		}, "203.0.113.4"},
+		{"forwarded", "[2001:db8::1]:443", map[string][]string{"Forwarded": {`for="[2001:db9::5]"`}}, "2001:db9::5"},
+		{server.HeaderForwarded, "10.0.0.2:1234", map[string][]string{"Forwarded": {"for=_hidden, for=10.4.4.4"}}, "10.4.4.4"},
+	} {
+		tp, err := server.NewTrustedProxies(c.header, cidrs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest("GET", "/note/", nil)
+		req.RemoteAddr = c.remote
+		for name, values := range c.headers {
+			for _, v := range values {
+				req.Header.Add(name, v)
+			}
+		}
+		if got := tp.ClientIP(req); got.String() != c.want {
+			t.Errorf("%s %s %v: got %s, want %s", c.header, c.remote, c.headers, got, c.want)
+		}
+	}
+
+	if _, err := server.NewTrustedProxies(server.HeaderXForwardedFor, []string{"10.0.0.0/33"}); err == nil {
+		t.Error("invalid CIDR accepted")
+	}
+	if _, err := server.NewTrustedProxies("X-Client-IP", cidrs); err == nil {
+		t.Error("unknown header accepted")
+	}
+}
+
+func TestTrustedProxies_Middleware(t *testing.T) {
+	tp, _ := server.NewTrustedProxies(server.HeaderXForwardedFor, []string{"10.0.0.0/8"})
+	var got string
+	handler := tp.Middleware(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		got = server.ClientIPFromContext(request.Context()).String()
+	}))
+
+	req := httptest.NewRequest("GET", "/note/", nil)
+	req.RemoteAddr = "10.0.0.2:1234"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if got != "198.51.100.1" {
+		t.Errorf("context holds %s", got)
+	}
+}
+
+func TestTrustedProxies_Audit(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	s := createUserServer()
+	tp, _ := server.NewTrustedProxies(server.HeaderXForwardedFor, []string{"10.0.0.0/8"})
+	handler := tp.Middleware(s.Authorize("notes.list", s.ListNotes()))
+
+	req := httptest.NewRequest("GET", "/note/", nil)
+	req.RemoteAddr = "10.0.0.2:1234"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	req.SetBasicAuth("pupa", "guess")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if !strings.Contains(logs.String(), "audit: rejected credentials ip=198.51.100.1") {
+		t.Errorf("rejected credentials logged as %q", logs.String())
+	}
+}
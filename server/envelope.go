@@ -0,0 +1,49 @@
+package server
+
+import (
+	"encoding/base64"
+	"errors"
+)
+
+// AlgorithmAES256GCM is the only envelope algorithm accepted for now. The
+// client encrypts the text with a 256-bit key that never leaves the URL
+// fragment, so the server sees nothing but the ciphertext and its nonce.
+const AlgorithmAES256GCM = "A256GCM"
+
+const (
+	gcmNonceSize = 12
+	gcmTagSize   = 16
+)
+
+var (
+	ErrPlaintextRefused = errors.New("plaintext notes are refused, send an encrypted envelope")
+	errEnvelopeAlg      = errors.New("unsupported envelope alg")
+	errEnvelopeNonce    = errors.New("envelope nonce must be 12 base64 encoded bytes")
+	errEnvelopeText     = errors.New("envelope text must be base64 encoded ciphertext")
+)
+
+// validateEnvelope checks the shape of an encrypted note. An empty alg means a
+// plaintext note, which is only allowed when the server isn't E2E-only.
+func (s *Server) validateEnvelope(text, nonce, alg string) error {
+	if alg == "" {
+		if nonce != "" {
+			return errEnvelopeAlg
+		}
+		if s.E2EOnly {
+			return ErrPlaintextRefused
+		}
+		return nil
+	}
+	if alg != AlgorithmAES256GCM {
+		return errEnvelopeAlg
+	}
+	n, err := base64.StdEncoding.DecodeString(nonce)
+	if err != nil || len(n) != gcmNonceSize {
+		return errEnvelopeNonce
+	}
+	ct, err := base64.StdEncoding.DecodeString(text)
+	if err != nil || len(ct) < gcmTagSize {
+		return errEnvelopeText
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"fmt"
+	"github.com/pimka/go-onenote/auth"
+	"net/http"
+	"strings"
+)
+
+const (
+	AuthModeBasic = "basic"
+	AuthModeJWT   = "jwt"
+)
+
+// JWTAuth accepts "Authorization: Bearer" JWT access tokens checked by v and
+// puts the principal from their claims into the request context. Requests
+// without a valid token stop here with 401.
+func JWTAuth(v *auth.JWTVerifier) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			header := request.Header.Get("Authorization")
+			if !strings.HasPrefix(header, "Bearer ") {
+				jwtUnauthorized(writer, "")
+				return
+			}
+			principal, err := v.Verify(strings.TrimPrefix(header, "Bearer "))
+			if err != nil {
+				audit(request.Context(), "rejected token: %v", err)
+				jwtUnauthorized(writer, `, error="invalid_token"`)
+				return
+			}
+
+			ctx := auth.WithPrincipal(request.Context(), principal)
+			next.ServeHTTP(writer, request.WithContext(ctx))
+		})
+	}
+}
+
+func jwtUnauthorized(writer http.ResponseWriter, params string) {
+	writer.Header().Set("WWW-Authenticate", `Bearer realm="Restricted"`+params)
+	http.Error(writer, "Unauthorized", http.StatusUnauthorized)
+}
+
+// NewAuthenticator builds the authentication middleware for the mode in c,
+// nil means the default.
+func NewAuthenticator(c Config) (func(http.Handler) http.Handler, error) {
+	switch c.AuthMode {
+	case AuthModeBasic, "":
+		return nil, nil
+	case AuthModeJWT:
+		v, err := auth.NewJWTVerifier(auth.JWTConfig{
+			Secret:        c.JWTSecret,
+			JWKSFile:      c.JWKSFile,
+			Issuer:        c.JWTIssuer,
+			Audience:      c.JWTAudience,
+			UsernameClaim: c.JWTUsernameClaim,
+			ScopeClaim:    c.JWTScopeClaim,
+			RoleClaim:     c.JWTRoleClaim,
+			DefaultRole:   c.JWTDefaultRole,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return JWTAuth(v), nil
+	}
+	return nil, fmt.Errorf("unknown auth mode %q", c.AuthMode)
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+const redisRatePrefix = "ratelimit:"
+
+// RedisLimiterStore shares the buckets between replicas. It runs GCRA: a
+// bucket is a single key holding its theoretical arrival time, the moment it
+// would be full again, and a request is let through unless that is more than
+// a burst worth of requests away. The script reads and moves it atomically.
+// The time comes from the replicas, their clocks should be kept in sync.
+// Policies with another algorithm are refused when the server starts.
+type RedisLimiterStore struct {
+	client *redis.Client
+}
+
+func NewRedisLimiterStore(client *redis.Client) *RedisLimiterStore {
+	return &RedisLimiterStore{client: client}
+}
+
+// times are in microseconds, string.format keeps them from being printed
+// in exponent notation
+var redisGCRAScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local burst = tonumber(ARGV[3])
+local tat = tonumber(redis.call('GET', KEYS[1]) or '') or now
+if tat < now then
+	tat = now
+end
+local allow_at = tat + interval - interval * burst
+if now < allow_at then
+	return {0, 0, tat - now, allow_at - now}
+end
+local new_tat = tat + interval
+redis.call('SET', KEYS[1], string.format('%.0f', new_tat), 'PX', math.ceil((new_tat - now) / 1000))
+return {1, math.floor((now - new_tat + interval * burst) / interval), new_tat - now, 0}
+`)
+
+func (r *RedisLimiterStore) Take(ctx context.Context, key string, p RatePolicy, now time.Time) (RateResult, error) {
+	interval := int64(float64(time.Second/time.Microsecond) / p.Rate)
+	if interval < 1 {
+		interval = 1
+	}
+	values, err := redisGCRAScript.Run(ctx, r.client, []string{redisRatePrefix + key},
+		now.UnixMicro(), interval, p.Burst).Int64Slice()
+	if err != nil {
+		return RateResult{}, err
+	}
+	return RateResult{
+		Allowed:    values[0] == 1,
+		Remaining:  int(values[1]),
+		Reset:      time.Duration(values[2]) * time.Microsecond,
+		RetryAfter: time.Duration(values[3]) * time.Microsecond,
+	}, nil
+}
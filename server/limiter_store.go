@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/pimka/go-onenote/db"
+	"time"
+)
+
+const (
+	LimiterStoreMemory = "memory"
+	LimiterStoreRedis  = "redis"
+)
+
+// RateResult is the state of a bucket after a request was taken from it.
+type RateResult struct {
+	Allowed bool
+	// Remaining is how many more requests the bucket lets through now.
+	Remaining int
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is how long a turned down request should wait.
+	RetryAfter time.Duration
+}
+
+// LimiterStore keeps the rate limit buckets.
+type LimiterStore interface {
+	// Take spends one request from the bucket of key, set up by p, at now.
+	Take(ctx context.Context, key string, p RatePolicy, now time.Time) (RateResult, error)
+}
+
+// Take makes VLimiter the in-memory store, every replica limits on its own.
+func (vl *VLimiter) Take(ctx context.Context, key string, p RatePolicy, now time.Time) (RateResult, error) {
+	lim, err := vl.GetVisitor(key, p)
+	if err != nil {
+		return RateResult{}, err
+	}
+	return lim.Take(now), nil
+}
+
+// checkStore makes sure store runs the algorithm of every policy, the Redis
+// store only runs GCRA.
+func (rp *RatePolicies) checkStore(store LimiterStore) error {
+	if _, ok := store.(*RedisLimiterStore); !ok {
+		return nil
+	}
+	for _, p := range rp.routes {
+		if p.Algorithm != "" && p.Algorithm != AlgorithmGCRA {
+			return fmt.Errorf("rate limit of %s: the %s store only runs %s, not %s", p.Route, LimiterStoreRedis, AlgorithmGCRA, p.Algorithm)
+		}
+	}
+	return nil
+}
+
+// NewLimiterStore returns the store c.RateLimitStore names, vl for memory.
+func NewLimiterStore(c Config, vl *VLimiter) (LimiterStore, error) {
+	switch c.RateLimitStore {
+	case LimiterStoreMemory, "":
+		return vl, nil
+	case LimiterStoreRedis:
+		client, err := db.OpenRedis(c.RateLimitRedisURL)
+		if err != nil {
+			return nil, err
+		}
+		return NewRedisLimiterStore(client), nil
+	}
+	return nil, fmt.Errorf("unknown rate limit store %q", c.RateLimitStore)
+}
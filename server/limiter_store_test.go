@@ -0,0 +1,107 @@
+package server_test
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/pimka/go-onenote/db"
+	"github.com/pimka/go-onenote/server"
+	"testing"
+	"time"
+)
+
+func testLimiterStore(t *testing.T, store server.LimiterStore) {
+	ctx := context.Background()
+	p := server.RatePolicy{Route: "notes.create", Rate: 1, Burst: 3, Key: server.RateKeyIP}
+	t0 := time.Now()
+
+	for i, want := range []server.RateResult{
+		{Allowed: true, Remaining: 2, Reset: time.Second},
+		{Allowed: true, Remaining: 1, Reset: 2 * time.Second},
+		{Allowed: true, Remaining: 0, Reset: 3 * time.Second},
+		{Allowed: false, Remaining: 0, Reset: 3 * time.Second, RetryAfter: time.Second},
+	} {
+		res, err := store.Take(ctx, "pupa", p, t0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Allowed != want.Allowed || res.Remaining != want.Remaining ||
+			!near(res.Reset, want.Reset) || !near(res.RetryAfter, want.RetryAfter) {
+			t.Fatalf("take %d: got %+v, want %+v", i, res, want)
+		}
+	}
+
+	if res, err := store.Take(ctx, "lupa", p, t0); err != nil || !res.Allowed || res.Remaining != 2 {
+		t.Fatalf("another key shares the bucket, %+v", res)
+	}
+	res, err := store.Take(ctx, "pupa", p, t0.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Allowed || res.Remaining != 0 {
+		t.Fatalf("bucket didn't refill, %+v", res)
+	}
+}
+
+func near(got, want time.Duration) bool {
+	d := got - want
+	return d > -time.Millisecond && d < time.Millisecond
+}
+
+func TestVLimiter_Take(t *testing.T) {
+	testLimiterStore(t, server.NewVLimiter(time.Minute))
+}
+
+func TestRedisLimiterStore(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client, err := db.OpenRedis("redis://" + mr.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	testLimiterStore(t, server.NewRedisLimiterStore(client))
+
+	// a second replica sees the buckets of the first
+	other, err := db.OpenRedis("redis://" + mr.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	p := server.RatePolicy{Route: "notes.create", Rate: 1, Burst: 1}
+	now := time.Now()
+	if res, err := server.NewRedisLimiterStore(client).Take(context.Background(), "shared", p, now); err != nil || !res.Allowed {
+		t.Fatalf("first take turned down, %+v %v", res, err)
+	}
+	if res, err := server.NewRedisLimiterStore(other).Take(context.Background(), "shared", p, now); err != nil || res.Allowed {
+		t.Fatalf("second replica went past the shared limit, %+v %v", res, err)
+	}
+
+	ttl := mr.TTL("ratelimit:shared")
+	if ttl <= 0 || ttl > time.Second {
+		t.Errorf("bucket key expires in %s", ttl)
+	}
+}
+
+func TestRedisLimiterStore_Algorithms(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client, err := db.OpenRedis("redis://" + mr.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	for limits, ok := range map[string]bool{
+		"notes.create=1:3":                true,
+		"notes.create=1:3:ip:gcra":        true,
+		"notes.create=1:3:ip:sliding-log": false,
+		"*=1:3:ip:token-bucket":           false,
+	} {
+		s := &server.Server{NH: db.NewMockDB(), RateStore: server.NewRedisLimiterStore(client)}
+		_, err := s.Handler(server.Config{DefaultRole: "editor", RateLimits: []string{limits}})
+		if ok && err != nil {
+			t.Errorf("%s refused, %v", limits, err)
+		}
+		if !ok && err == nil {
+			t.Errorf("%s accepted by the redis store", limits)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package server
+
+import (
+	"fmt"
+	"golang.org/x/time/rate"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	AlgorithmTokenBucket   = "token-bucket"
+	AlgorithmSlidingLog    = "sliding-log"
+	AlgorithmSlidingWindow = "sliding-window"
+	AlgorithmGCRA          = "gcra"
+)
+
+// Limiter is the bucket of one visitor. Every algorithm lets Rate requests
+// per second through in the long run and at most Burst at once, the sliding
+// windows count Burst requests per Burst/Rate seconds.
+type Limiter interface {
+	// Take spends one request at now.
+	Take(now time.Time) RateResult
+	// Cleanup drops what the limiter no longer needs at now, VisitorsPurger
+	// calls it for the visitors it keeps.
+	Cleanup(now time.Time)
+}
+
+// NewLimiter sets up a limiter running the algorithm of p.
+func NewLimiter(p RatePolicy) (Limiter, error) {
+	switch p.Algorithm {
+	case AlgorithmTokenBucket, "":
+		return &tokenBucket{limiter: rate.NewLimiter(rate.Limit(p.Rate), p.Burst), policy: p}, nil
+	case AlgorithmSlidingLog, AlgorithmSlidingWindow:
+		window, err := duration("window", float64(p.Burst)/p.Rate)
+		if err != nil {
+			return nil, err
+		}
+		if p.Algorithm == AlgorithmSlidingLog {
+			return &slidingLog{window: window, burst: p.Burst}, nil
+		}
+		return &slidingWindow{window: window, burst: p.Burst}, nil
+	case AlgorithmGCRA:
+		interval, err := duration("interval", 1/p.Rate)
+		if err != nil {
+			return nil, err
+		}
+		return &gcra{interval: interval, burst: p.Burst}, nil
+	}
+	return nil, fmt.Errorf("unknown rate limit algorithm %q", p.Algorithm)
+}
+
+// duration turns seconds into a Duration, rates too fast or too slow for a
+// whole number of nanoseconds are refused.
+func duration(what string, seconds float64) (time.Duration, error) {
+	d := seconds * float64(time.Second)
+	if !(d >= 1) || d >= math.MaxInt64 {
+		return 0, fmt.Errorf("%s of %gs is out of range", what, seconds)
+	}
+	return time.Duration(d), nil
+}
+
+// tokenBucket is golang.org/x/time/rate, it locks itself.
+type tokenBucket struct {
+	limiter *rate.Limiter
+	policy  RatePolicy
+}
+
+func (tb *tokenBucket) Take(now time.Time) RateResult {
+	allowed := tb.limiter.AllowN(now, 1)
+	tokens := tb.limiter.TokensAt(now)
+
+	res := RateResult{
+		Allowed:   allowed,
+		Remaining: int(math.Max(0, math.Floor(tokens))),
+		Reset:     tb.refill(tokens, float64(tb.policy.Burst)),
+	}
+	if !allowed {
+		res.RetryAfter = tb.refill(tokens, 1)
+	}
+	return res
+}
+
+// refill is how long the bucket takes to get from tokens to want.
+func (tb *tokenBucket) refill(tokens, want float64) time.Duration {
+	if tokens >= want {
+		return 0
+	}
+	return time.Duration((want - tokens) / tb.policy.Rate * float64(time.Second))
+}
+
+func (tb *tokenBucket) Cleanup(now time.Time) {}
+
+// slidingLog keeps the time of every request in the window, exact but it
+// costs memory per request.
+type slidingLog struct {
+	mu     sync.Mutex
+	window time.Duration
+	burst  int
+	log    []time.Time
+}
+
+func (sl *slidingLog) Take(now time.Time) RateResult {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	sl.prune(now)
+	if len(sl.log) >= sl.burst {
+		return RateResult{
+			Reset:      sl.log[len(sl.log)-1].Add(sl.window).Sub(now),
+			RetryAfter: sl.log[0].Add(sl.window).Sub(now),
+		}
+	}
+	sl.log = append(sl.log, now)
+	return RateResult{
+		Allowed:   true,
+		Remaining: sl.burst - len(sl.log),
+		Reset:     sl.window,
+	}
+}
+
+// prune drops the requests that left the window, the log is in time order.
+func (sl *slidingLog) prune(now time.Time) {
+	i := 0
+	for i < len(sl.log) && !sl.log[i].Add(sl.window).After(now) {
+		i++
+	}
+	if i > 0 {
+		sl.log = append(sl.log[:0], sl.log[i:]...)
+	}
+}
+
+func (sl *slidingLog) Cleanup(now time.Time) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	sl.prune(now)
+}
+
+// slidingWindow counts requests in fixed windows and weighs the previous
+// one by how much of it still overlaps the sliding window, two counters
+// per visitor at the price of assuming the previous window was even.
+type slidingWindow struct {
+	mu       sync.Mutex
+	window   time.Duration
+	burst    int
+	start    time.Time
+	previous int
+	current  int
+}
+
+// advance moves the fixed windows up to now.
+func (sw *slidingWindow) advance(now time.Time) {
+	if sw.start.IsZero() {
+		sw.start = now.Truncate(sw.window)
+	}
+	switch passed := now.Sub(sw.start) / sw.window; {
+	case passed == 1:
+		sw.previous, sw.current = sw.current, 0
+		sw.start = sw.start.Add(sw.window)
+	case passed > 1:
+		sw.previous, sw.current = 0, 0
+		sw.start = now.Truncate(sw.window)
+	}
+}
+
+func (sw *slidingWindow) Take(now time.Time) RateResult {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	sw.advance(now)
+	elapsed := now.Sub(sw.start)
+	estimate := float64(int64(sw.previous)*int64(sw.window-elapsed))/float64(sw.window) + float64(sw.current)
+	if estimate+1 > float64(sw.burst) {
+		return RateResult{Reset: sw.reset(elapsed), RetryAfter: sw.retryAfter(elapsed)}
+	}
+	sw.current++
+	return RateResult{
+		Allowed:   true,
+		Remaining: int(math.Max(0, math.Floor(float64(sw.burst)-estimate-1))),
+		Reset:     sw.reset(elapsed),
+	}
+}
+
+// reset is when nothing counts against the visitor any more.
+func (sw *slidingWindow) reset(elapsed time.Duration) time.Duration {
+	switch {
+	case sw.current > 0:
+		return 2*sw.window - elapsed
+	case sw.previous > 0:
+		return sw.window - elapsed
+	}
+	return 0
+}
+
+// retryAfter is when the estimate leaves room for one more request, rounded
+// up so a client waiting that long isn't turned down again.
+func (sw *slidingWindow) retryAfter(elapsed time.Duration) time.Duration {
+	room := sw.burst - 1
+	if sw.current <= room && sw.previous > 0 {
+		// the previous window fades out far enough within this one
+		return ceilDiv(sw.window, sw.previous-room+sw.current, sw.previous) - elapsed
+	}
+	// wait for the current window to become the previous one
+	return sw.window - elapsed + ceilDiv(sw.window, sw.current-room, sw.current)
+}
+
+// ceilDiv is d*n/m rounded up.
+func ceilDiv(d time.Duration, n, m int) time.Duration {
+	return (d*time.Duration(n) + time.Duration(m) - 1) / time.Duration(m)
+}
+
+func (sw *slidingWindow) Cleanup(now time.Time) {}
+
+// gcra tracks only the theoretical arrival time, the moment the bucket would
+// be full again. RedisLimiterStore runs the same algorithm.
+type gcra struct {
+	mu       sync.Mutex
+	interval time.Duration
+	burst    int
+	tat      time.Time
+}
+
+func (g *gcra) Take(now time.Time) RateResult {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	tat := g.tat
+	if tat.Before(now) {
+		tat = now
+	}
+	tolerance := time.Duration(g.burst) * g.interval
+	allowAt := tat.Add(g.interval - tolerance)
+	if now.Before(allowAt) {
+		return RateResult{Reset: tat.Sub(now), RetryAfter: allowAt.Sub(now)}
+	}
+	g.tat = tat.Add(g.interval)
+	return RateResult{
+		Allowed:   true,
+		Remaining: int((now.Sub(g.tat) + tolerance) / g.interval),
+		Reset:     g.tat.Sub(now),
+	}
+}
+
+func (g *gcra) Cleanup(now time.Time) {}
@@ -0,0 +1,104 @@
+package server_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/pimka/go-onenote/server"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestLimiters(t *testing.T) {
+	// on a boundary of the 3s windows
+	t0 := time.Unix(999, 0)
+	for _, algorithm := range []string{
+		server.AlgorithmTokenBucket,
+		server.AlgorithmSlidingLog,
+		server.AlgorithmSlidingWindow,
+		server.AlgorithmGCRA,
+	} {
+		lim, err := server.NewLimiter(server.RatePolicy{Route: "notes.create", Rate: 1, Burst: 3, Algorithm: algorithm})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 2; i >= 0; i-- {
+			if res := lim.Take(t0); !res.Allowed || res.Remaining != i || res.Reset <= 0 {
+				t.Fatalf("%s: take within the burst got %+v", algorithm, res)
+			}
+		}
+		res := lim.Take(t0)
+		if res.Allowed || res.Remaining != 0 || res.RetryAfter <= 0 {
+			t.Fatalf("%s: take past the burst got %+v", algorithm, res)
+		}
+		retry := t0.Add(res.RetryAfter)
+		if early := lim.Take(retry.Add(-10 * time.Millisecond)); early.Allowed {
+			t.Errorf("%s: take before Retry-After let through", algorithm)
+		}
+		if late := lim.Take(retry); !late.Allowed {
+			t.Errorf("%s: take at Retry-After turned down, %+v", algorithm, late)
+		}
+		lim.Cleanup(retry.Add(time.Hour))
+		if fresh := lim.Take(retry.Add(time.Hour)); !fresh.Allowed || fresh.Remaining != 2 {
+			t.Errorf("%s: idle limiter didn't refill, %+v", algorithm, fresh)
+		}
+	}
+
+	if _, err := server.NewLimiter(server.RatePolicy{Rate: 1, Burst: 1, Algorithm: "leaky"}); err == nil {
+		t.Error("unknown algorithm accepted")
+	}
+	for _, p := range []server.RatePolicy{
+		{Rate: 1e10, Burst: 1, Algorithm: server.AlgorithmGCRA},
+		{Rate: 1e300, Burst: 1, Algorithm: server.AlgorithmSlidingWindow},
+		{Rate: 1e300, Burst: 1, Algorithm: server.AlgorithmSlidingLog},
+		{Rate: 1e-300, Burst: 1, Algorithm: server.AlgorithmGCRA},
+	} {
+		if _, err := server.NewLimiter(p); err == nil {
+			t.Errorf("%s at %g/s accepted", p.Algorithm, p.Rate)
+		}
+	}
+}
+
+// BenchmarkLimiters compares the algorithms under many distinct IPs, CPU per
+// request and the memory each visitor holds once every IP has been seen.
+func BenchmarkLimiters(b *testing.B) {
+	const visitors = 100000
+	keys := make([]string, visitors)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("ip:10.%d.%d.%d", i>>16&0xff, i>>8&0xff, i&0xff)
+	}
+	ctx := context.Background()
+
+	for _, algorithm := range []string{
+		server.AlgorithmTokenBucket,
+		server.AlgorithmSlidingLog,
+		server.AlgorithmSlidingWindow,
+		server.AlgorithmGCRA,
+	} {
+		b.Run(algorithm, func(b *testing.B) {
+			p := server.RatePolicy{Route: "notes.create", Rate: 5, Burst: 10, Algorithm: algorithm}
+			vl := server.NewVLimiter(time.Minute)
+			now := time.Now()
+
+			var before, after runtime.MemStats
+			runtime.GC()
+			runtime.ReadMemStats(&before)
+			for _, key := range keys {
+				for i := 0; i < p.Burst; i++ {
+					vl.Take(ctx, key, p, now)
+				}
+			}
+			runtime.GC()
+			runtime.ReadMemStats(&after)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				vl.Take(ctx, keys[i%visitors], p, now.Add(time.Duration(i)*time.Microsecond))
+			}
+			// after the loop, ResetTimer drops extra metrics
+			b.ReportMetric(float64(after.HeapAlloc-before.HeapAlloc)/visitors, "B/visitor")
+		})
+	}
+}
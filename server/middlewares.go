@@ -1,92 +1,79 @@
 package server
 
 import (
-	"golang.org/x/time/rate"
-	"net"
-	"net/http"
 	"sync"
 	"time"
 )
 
+const DefaultVisitorIdle = 3 * time.Minute
+
 type Visitor struct {
-	Limiter  *rate.Limiter
+	Limiter  Limiter
 	LastSeen time.Time
 }
 
 type VLimiter struct {
 	Visitors map[string]*Visitor
-	mu       sync.Mutex
+	// Idle is how long a visitor is kept after its last request,
+	// DefaultVisitorIdle when zero.
+	Idle time.Duration
+	mu   sync.Mutex
+}
+
+func NewVLimiter(idle time.Duration) *VLimiter {
+	return &VLimiter{Visitors: make(map[string]*Visitor), Idle: idle}
 }
 
 type VisitorsPurger struct {
-	limiter     VLimiter
+	limiter     *VLimiter
 	off         chan struct{}
 	done        chan struct{}
 	timeout     time.Duration
 	maxErrCount int
 }
 
-func SimpleAuth(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
-		user, pass, _ := request.BasicAuth()
-
-		if user != "pupa" || pass != "pupa" {
-			writer.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
-			http.Error(writer, "Unauthorized", http.StatusUnauthorized)
-		}
-
-		next.ServeHTTP(writer, request)
-	})
-}
-
-func (vl *VLimiter) GetVisitor(ip string) *rate.Limiter {
+// GetVisitor returns the limiter of key, a new one set up by p for keys it
+// hasn't seen.
+func (vl *VLimiter) GetVisitor(key string, p RatePolicy) (Limiter, error) {
 	vl.mu.Lock()
 	defer vl.mu.Unlock()
 
-	visitor, ex := vl.Visitors[ip]
+	visitor, ex := vl.Visitors[key]
 	if !ex {
-		limit := rate.NewLimiter(5, 10)
-		vl.Visitors[ip] = &Visitor{
+		limit, err := NewLimiter(p)
+		if err != nil {
+			return nil, err
+		}
+		vl.Visitors[key] = &Visitor{
 			Limiter:  limit,
 			LastSeen: time.Now(),
 		}
-		return limit
+		return limit, nil
 
 	}
 	visitor.LastSeen = time.Now()
-	return visitor.Limiter
-}
-
-func Limiter(next http.Handler, vl *VLimiter) http.Handler {
-	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
-		ip, _, err := net.SplitHostPort(request.RemoteAddr)
-		if err != nil {
-			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-			return
-		}
-
-		lim := vl.GetVisitor(ip)
-		if lim.Allow() == false {
-			http.Error(writer, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
-			return
-		}
-
-		next.ServeHTTP(writer, request)
-	})
+	return visitor.Limiter, nil
 }
 
 func (vl *VLimiter) VisitorsCleaner() {
 	vl.mu.Lock()
 	defer vl.mu.Unlock()
 
-	for ip, v := range vl.Visitors {
-		if time.Since(v.LastSeen) > 3*time.Minute {
-			delete(vl.Visitors, ip)
+	idle := vl.Idle
+	if idle <= 0 {
+		idle = DefaultVisitorIdle
+	}
+	now := time.Now()
+	for key, v := range vl.Visitors {
+		if now.Sub(v.LastSeen) > idle {
+			delete(vl.Visitors, key)
+			continue
 		}
+		v.Limiter.Cleanup(now)
 	}
 }
 
-func NewPurger(vl VLimiter, timeout time.Duration, maxErrCount int) *VisitorsPurger {
+func NewPurger(vl *VLimiter, timeout time.Duration, maxErrCount int) *VisitorsPurger {
 	return &VisitorsPurger{
 		limiter:     vl,
 		off:         make(chan struct{}, 1),
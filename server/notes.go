@@ -2,22 +2,63 @@ package server
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/gofrs/uuid"
 	"github.com/gorilla/mux"
+	"github.com/pimka/go-onenote/auth"
+	"github.com/pimka/go-onenote/db"
+	"github.com/pimka/go-onenote/secret"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 )
 
+// ListNotes lists the notes of the caller. Listed notes carry their metadata
+// only, the text is left out and is read through the note's token.
 func (s *Server) ListNotes() http.HandlerFunc {
+	return s.listNotes(false)
+}
+
+// ListAllNotes lists the notes of every owner, anonymous ones included.
+func (s *Server) ListAllNotes() http.HandlerFunc {
+	return s.listNotes(true)
+}
+
+func (s *Server) listNotes(allOwners bool) http.HandlerFunc {
+	type responseBody struct {
+		Notes      []*db.Note `json:"notes"`
+		NextCursor string     `json:"next_cursor"`
+	}
 	return func(writer http.ResponseWriter, request *http.Request) {
 		ctx := request.Context()
-		notes, err := s.NH.List(ctx)
+		filter, err := parseListFilter(request.URL.Query())
 		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter.Owner = auth.FromContext(ctx).ID()
+		filter.AllOwners = allOwners
+
+		notes, next, err := s.NH.List(ctx, filter)
+		if err != nil {
+			if err == db.ErrInvalidCursor {
+				http.Error(writer, err.Error(), http.StatusBadRequest)
+				return
+			}
 			writer.WriteHeader(http.StatusInternalServerError)
 			return
 		}
+		if notes == nil {
+			notes = []*db.Note{}
+		}
+		for i, n := range notes {
+			notes[i] = publicNote(n)
+			notes[i].Text = ""
+		}
 
-		notesJson, err := json.Marshal(notes)
+		notesJson, err := json.Marshal(responseBody{Notes: notes, NextCursor: next})
 		if err != nil {
 			writer.WriteHeader(http.StatusInternalServerError)
 			return
@@ -27,10 +68,45 @@ func (s *Server) ListNotes() http.HandlerFunc {
 	}
 }
 
+func parseListFilter(query url.Values) (db.ListFilter, error) {
+	var err error
+	f := db.ListFilter{Cursor: query.Get("cursor")}
+
+	if v := query.Get("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit <= 0 {
+			return f, fmt.Errorf("invalid limit %q", v)
+		}
+	}
+	if v := query.Get("created_before"); v != "" {
+		if f.CreatedBefore, err = time.Parse(time.RFC3339, v); err != nil {
+			return f, fmt.Errorf("invalid created_before %q", v)
+		}
+	}
+	if v := query.Get("created_after"); v != "" {
+		if f.CreatedAfter, err = time.Parse(time.RFC3339, v); err != nil {
+			return f, fmt.Errorf("invalid created_after %q", v)
+		}
+	}
+	if v := query.Get("expiring_within"); v != "" {
+		if f.ExpiringWithin, err = time.ParseDuration(v); err != nil || f.ExpiringWithin <= 0 {
+			return f, fmt.Errorf("invalid expiring_within %q", v)
+		}
+	}
+	return f, nil
+}
+
 func (s *Server) AddNote() http.HandlerFunc {
 	type requestBody struct {
 		Text       string `json:"text"`
 		Expiration int    `json:"expiration"`
+		MaxViews   int    `json:"max_views"`
+		Nonce      string `json:"nonce"`
+		Algorithm  string `json:"alg"`
+		Passphrase string `json:"passphrase"`
+	}
+	type responseBody struct {
+		*db.Note
+		Token string `json:"token"`
 	}
 	return func(writer http.ResponseWriter, request *http.Request) {
 		var r requestBody
@@ -44,20 +120,49 @@ func (s *Server) AddNote() http.HandlerFunc {
 			http.Error(writer, err.Error(), http.StatusUnprocessableEntity)
 			return
 		}
+		if r.MaxViews < 0 {
+			http.Error(writer, "max_views must not be negative", http.StatusUnprocessableEntity)
+			return
+		}
+		if err = s.validateEnvelope(r.Text, r.Nonce, r.Algorithm); err != nil {
+			http.Error(writer, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
 
 		ctx := request.Context()
-		uid, err := uuid.NewV4()
+		token, uid, tokenKey, err := newNoteToken()
 		if err != nil {
 			writer.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		note, err := s.NH.Create(ctx, uid, r.Text, r.Expiration)
+		n := &db.Note{
+			ID:         uid,
+			OwnerID:    auth.FromContext(ctx).ID(),
+			Expiration: r.Expiration,
+			MaxViews:   r.MaxViews,
+			Nonce:      r.Nonce,
+			Algorithm:  r.Algorithm,
+		}
+		var passKey []byte
+		if pass := passphrase(request, r.Passphrase); pass != "" {
+			if n.PassHash, passKey, err = secret.HashPassphrase(pass); err != nil {
+				writer.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+		if n.Text, err = sealText(tokenKey, passKey, r.Text); err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		note, err := s.NH.Create(ctx, n)
 		if err != nil {
 			writer.WriteHeader(http.StatusInternalServerError)
 			return
 		}
+		response := publicNote(note)
+		response.Text = r.Text
 
-		noteJson, err := json.Marshal(note)
+		noteJson, err := json.Marshal(responseBody{Note: response, Token: token})
 		if err != nil {
 			writer.WriteHeader(http.StatusInternalServerError)
 			return
@@ -69,16 +174,16 @@ func (s *Server) AddNote() http.HandlerFunc {
 
 func (s *Server) UpdateNote() http.HandlerFunc {
 	type requestBody struct {
-		Text string `json:"text"`
+		Text       string `json:"text"`
+		Nonce      string `json:"nonce"`
+		Algorithm  string `json:"alg"`
+		Passphrase string `json:"passphrase"`
 	}
 	return func(writer http.ResponseWriter, request *http.Request) {
 		var r requestBody
 		ctx := request.Context()
-		vars := mux.Vars(request)
-		uidStr := vars["uid"]
-		uid, err := uuid.FromString(uidStr)
-		if err != nil {
-			writer.WriteHeader(http.StatusBadRequest)
+		uid, tokenKey, link, ok := s.resolveNote(ctx, writer, mux.Vars(request)["token"], db.SharePermReadWrite)
+		if !ok {
 			return
 		}
 
@@ -93,13 +198,48 @@ func (s *Server) UpdateNote() http.HandlerFunc {
 			return
 		}
 
-		note, err := s.NH.Update(ctx, uid, r.Text)
+		if err = s.validateEnvelope(r.Text, r.Nonce, r.Algorithm); err != nil {
+			http.Error(writer, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		note, err := s.NH.Get(ctx, uid)
 		if err != nil {
 			writer.WriteHeader(http.StatusInternalServerError)
 			return
 		}
+		if note == nil {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		passKey, ok := s.unlock(ctx, writer, note, passphrase(request, r.Passphrase))
+		if !ok {
+			return
+		}
+		content := &db.Note{Nonce: r.Nonce, Algorithm: r.Algorithm}
+		if content.Text, err = sealText(tokenKey, passKey, r.Text); err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
 
-		noteJson, err := json.Marshal(note)
+		// a read-write share link acts on behalf of the note's owner
+		owner := auth.FromContext(ctx).ID()
+		if link != nil {
+			owner = note.OwnerID
+		}
+		note, err = s.NH.Update(ctx, owner, uid, content)
+		if err != nil {
+			if err == db.ErrNotFound {
+				writer.WriteHeader(http.StatusNotFound)
+				return
+			}
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		response := publicNote(note)
+		response.Text = r.Text
+
+		noteJson, err := json.Marshal(response)
 		if err != nil {
 			writer.WriteHeader(http.StatusInternalServerError)
 			return
@@ -110,22 +250,70 @@ func (s *Server) UpdateNote() http.HandlerFunc {
 }
 
 func (s *Server) GetNote() http.HandlerFunc {
+	type requestBody struct {
+		Passphrase string `json:"passphrase"`
+	}
 	return func(writer http.ResponseWriter, request *http.Request) {
-		vars := mux.Vars(request)
-		uidStr := vars["uid"]
-		uid, err := uuid.FromString(uidStr)
-		if err != nil {
-			writer.WriteHeader(http.StatusBadRequest)
+		var r requestBody
+		ctx := request.Context()
+		uid, tokenKey, link, ok := s.resolveNote(ctx, writer, mux.Vars(request)["token"], db.SharePermRead)
+		if !ok {
 			return
 		}
-		ctx := request.Context()
+
+		if request.Body != nil {
+			bytes, err := ioutil.ReadAll(request.Body)
+			if err != nil {
+				writer.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if len(bytes) > 0 {
+				if err = json.Unmarshal(bytes, &r); err != nil {
+					http.Error(writer, err.Error(), http.StatusUnprocessableEntity)
+					return
+				}
+			}
+		}
 
 		note, err := s.NH.Get(ctx, uid)
 		if err != nil {
 			writer.WriteHeader(http.StatusNoContent)
 			return
 		}
-		nJson, err := json.Marshal(note)
+		if note == nil {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		passKey, ok := s.unlock(ctx, writer, note, passphrase(request, r.Passphrase))
+		if !ok {
+			return
+		}
+		if link != nil {
+			if link, err = s.Shares.UseShare(ctx, link.ID); err != nil {
+				writer.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if link == nil {
+				writer.WriteHeader(http.StatusNotFound)
+				return
+			}
+		}
+
+		note, err = s.NH.View(ctx, uid)
+		if err != nil {
+			writer.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if note == nil {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		response := publicNote(note)
+		if response.Text, err = openText(tokenKey, passKey, note.Text); err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		nJson, err := json.Marshal(response)
 		if err != nil {
 			writer.WriteHeader(http.StatusInternalServerError)
 			return
@@ -138,19 +326,45 @@ func (s *Server) GetNote() http.HandlerFunc {
 func (s *Server) DeleteNote() http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
 		vars := mux.Vars(request)
-		uidStr := vars["uid"]
-		uid, err := uuid.FromString(uidStr)
+		uid, _, err := noteToken(vars["token"])
 		if err != nil {
 			writer.WriteHeader(http.StatusBadRequest)
 			return
 		}
 		ctx := request.Context()
 
-		_, err = s.NH.Delete(ctx, uid)
+		note, err := s.NH.Delete(ctx, auth.FromContext(ctx).ID(), uid)
 		if err != nil {
 			writer.WriteHeader(http.StatusInternalServerError)
 			return
 		}
+		if note == nil {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// PurgeNote deletes a note by its ID whoever owns it.
+func (s *Server) PurgeNote() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		uid, err := uuid.FromString(mux.Vars(request)["id"])
+		if err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		note, err := s.NH.Purge(request.Context(), uid)
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if note == nil {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		audit(request.Context(), "note %s purged", uid)
 		writer.WriteHeader(http.StatusNoContent)
 	}
 }
@@ -160,7 +374,7 @@ func (s *Server) PeekNote() http.HandlerFunc {
 		Exist bool `json:"exist"`
 	}
 	type requestBody struct {
-		ID uuid.UUID `json:"id"`
+		Token string `json:"token"`
 	}
 	return func(writer http.ResponseWriter, request *http.Request) {
 		var r requestBody
@@ -176,7 +390,12 @@ func (s *Server) PeekNote() http.HandlerFunc {
 			http.Error(writer, err.Error(), http.StatusUnprocessableEntity)
 			return
 		}
-		note, err := s.NH.Get(ctx, r.ID)
+		uid, _, err := noteToken(r.Token)
+		if err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		note, err := s.NH.Get(ctx, uid)
 		if err != nil {
 			writer.WriteHeader(http.StatusInternalServerError)
 			return
@@ -207,7 +426,13 @@ func (s *Server) PeekNote() http.HandlerFunc {
 
 func (s *Server) PopNote() http.HandlerFunc {
 	type requestBody struct {
-		ID uuid.UUID `json:"id"`
+		Token      string `json:"token"`
+		Passphrase string `json:"passphrase"`
+	}
+	type responseBody struct {
+		*db.Note
+		LeaseToken   uuid.UUID `json:"lease_token"`
+		LeaseExpires time.Time `json:"lease_expires"`
 	}
 	return func(writer http.ResponseWriter, request *http.Request) {
 		var r requestBody
@@ -224,7 +449,30 @@ func (s *Server) PopNote() http.HandlerFunc {
 			return
 		}
 
-		note, err := s.NH.Delete(ctx, r.ID)
+		lease := s.PopLease
+		if lease <= 0 {
+			lease = DefaultPopLease
+		}
+		uid, tokenKey, err := noteToken(r.Token)
+		if err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		note, err := s.NH.Get(ctx, uid)
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if note == nil {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		passKey, ok := s.unlock(ctx, writer, note, passphrase(request, r.Passphrase))
+		if !ok {
+			return
+		}
+
+		note, token, err := s.NH.Reserve(ctx, uid, lease)
 		if err != nil {
 			writer.WriteHeader(http.StatusInternalServerError)
 			return
@@ -233,8 +481,17 @@ func (s *Server) PopNote() http.HandlerFunc {
 			writer.WriteHeader(http.StatusNotFound)
 			return
 		}
+		response := publicNote(note)
+		if response.Text, err = openText(tokenKey, passKey, note.Text); err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
 
-		noteJson, err := json.Marshal(note)
+		noteJson, err := json.Marshal(responseBody{
+			Note:         response,
+			LeaseToken:   token,
+			LeaseExpires: time.Now().Add(lease),
+		})
 		if err != nil {
 			writer.WriteHeader(http.StatusInternalServerError)
 			return
@@ -244,3 +501,37 @@ func (s *Server) PopNote() http.HandlerFunc {
 		writer.Write(noteJson)
 	}
 }
+
+func (s *Server) AckNote() http.HandlerFunc {
+	type requestBody struct {
+		ID         uuid.UUID `json:"id"`
+		LeaseToken uuid.UUID `json:"lease_token"`
+	}
+	return func(writer http.ResponseWriter, request *http.Request) {
+		var r requestBody
+		ctx := request.Context()
+		bytes, err := ioutil.ReadAll(request.Body)
+		if err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		err = json.Unmarshal(bytes, &r)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		acked, err := s.NH.Ack(ctx, r.ID, r.LeaseToken)
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !acked {
+			// unknown note, wrong token or the lease has run out
+			writer.WriteHeader(http.StatusConflict)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"github.com/pimka/go-onenote/db"
+	"github.com/pimka/go-onenote/secret"
+	"net/http"
+)
+
+// PassphraseHeader carries the passphrase of a protected note, clients that
+// can't set headers may send it as "passphrase" in the JSON body instead.
+const PassphraseHeader = "X-Note-Passphrase"
+
+const DefaultPassphraseAttempts = 5
+
+// unlock checks the passphrase for a protected note and returns the key its
+// text is sealed with, nil for notes without a passphrase. A wrong passphrase
+// is counted against the note and answered with 403, the note is only burnt
+// once it runs out of attempts.
+func (s *Server) unlock(ctx context.Context, writer http.ResponseWriter, note *db.Note, pass string) ([]byte, bool) {
+	if note.PassHash == "" {
+		return nil, true
+	}
+	key, ok, err := secret.VerifyPassphrase(note.PassHash, pass)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		return nil, false
+	}
+	if ok {
+		return key, true
+	}
+
+	attempts := s.PassphraseAttempts
+	if attempts <= 0 {
+		attempts = DefaultPassphraseAttempts
+	}
+	burnt, err := s.NH.FailAttempt(ctx, note.ID, attempts)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		return nil, false
+	}
+	audit(ctx, "wrong passphrase for note %s, burnt: %t", note.ID, burnt)
+	http.Error(writer, "wrong passphrase", http.StatusForbidden)
+	return nil, false
+}
+
+func passphrase(request *http.Request, body string) string {
+	if pass := request.Header.Get(PassphraseHeader); pass != "" {
+		return pass
+	}
+	return body
+}
+
+// publicNote strips what only the server needs to know about a note.
+func publicNote(n *db.Note) *db.Note {
+	c := *n
+	c.PassHash = ""
+	c.FailedAttempts = 0
+	return &c
+}
@@ -0,0 +1,322 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/pimka/go-onenote/auth"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	RateKeyIP    = "ip"
+	RateKeyUser  = "user"
+	RateKeyToken = "token"
+)
+
+// DefaultRoute is the target of the policy for routes without their own.
+const DefaultRoute = "*"
+
+// RatePolicy limits the requests to a route. Route is a route name such as
+// "notes.create", a method and path template such as "POST /note/", or
+// DefaultRoute. Rate is in requests per second.
+type RatePolicy struct {
+	Route string  `json:"route"`
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+	// Key tells whose requests share a bucket: the client IP, the user
+	// or the credentials. Every request goes by its IP first, users and
+	// credentials are limited on top of that once Authorize verified them.
+	Key string `json:"key"`
+	// Algorithm is the Limiter of the buckets, the token bucket when empty.
+	Algorithm string `json:"algorithm,omitempty"`
+}
+
+var defaultRatePolicy = RatePolicy{Route: DefaultRoute, Rate: 5, Burst: 10, Key: RateKeyIP}
+
+func (p RatePolicy) validate() error {
+	if p.Route == "" {
+		return fmt.Errorf("rate limit without a route")
+	}
+	if p.Rate <= 0 {
+		return fmt.Errorf("rate limit of %s: rate must be positive", p.Route)
+	}
+	if p.Burst < 1 {
+		return fmt.Errorf("rate limit of %s: burst must be at least 1", p.Route)
+	}
+	switch p.Key {
+	case RateKeyIP, RateKeyUser, RateKeyToken:
+	default:
+		return fmt.Errorf("rate limit of %s: unknown key %q", p.Route, p.Key)
+	}
+	if _, err := NewLimiter(p); err != nil {
+		return fmt.Errorf("rate limit of %s: %w", p.Route, err)
+	}
+	return nil
+}
+
+// RatePolicies holds the rate limits of every route.
+type RatePolicies struct {
+	routes map[string]RatePolicy
+}
+
+// NewRatePolicies checks policies, later ones override earlier ones for the
+// same route. Routes without a policy get 5 requests per second with a burst
+// of 10 per IP unless there is a DefaultRoute policy.
+func NewRatePolicies(policies ...RatePolicy) (*RatePolicies, error) {
+	rp := &RatePolicies{routes: map[string]RatePolicy{DefaultRoute: defaultRatePolicy}}
+	for _, p := range policies {
+		if p.Key == "" {
+			p.Key = RateKeyIP
+		}
+		if err := p.validate(); err != nil {
+			return nil, err
+		}
+		rp.routes[p.Route] = p
+	}
+	return rp, nil
+}
+
+// LoadRatePolicies reads the policies of c, RATE_LIMIT_FILE first and then
+// RATE_LIMITS.
+func LoadRatePolicies(c Config) (*RatePolicies, error) {
+	var policies []RatePolicy
+	if c.RateLimitFile != "" {
+		data, err := os.ReadFile(c.RateLimitFile)
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(data, &policies); err != nil {
+			return nil, fmt.Errorf("rate limit file %s: %w", c.RateLimitFile, err)
+		}
+	}
+	for _, entry := range c.RateLimits {
+		p, err := parseRatePolicy(entry)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, p)
+	}
+	return NewRatePolicies(policies...)
+}
+
+// parseRatePolicy reads "<route>=<rate>:<burst>[:<key>[:<algorithm>]]".
+func parseRatePolicy(entry string) (RatePolicy, error) {
+	var p RatePolicy
+	i := strings.LastIndex(entry, "=")
+	if i < 0 {
+		return p, fmt.Errorf("invalid rate limit %q", entry)
+	}
+	p.Route = strings.TrimSpace(entry[:i])
+	parts := strings.Split(entry[i+1:], ":")
+	if len(parts) < 2 || len(parts) > 4 {
+		return p, fmt.Errorf("invalid rate limit %q", entry)
+	}
+	var err error
+	if p.Rate, err = strconv.ParseFloat(parts[0], 64); err != nil {
+		return p, fmt.Errorf("invalid rate limit %q", entry)
+	}
+	if p.Burst, err = strconv.Atoi(parts[1]); err != nil {
+		return p, fmt.Errorf("invalid rate limit %q", entry)
+	}
+	if len(parts) > 2 {
+		p.Key = parts[2]
+	}
+	if len(parts) > 3 {
+		p.Algorithm = parts[3]
+	}
+	return p, nil
+}
+
+// policy finds the policy of the route request matched.
+func (rp *RatePolicies) policy(request *http.Request) RatePolicy {
+	if route := mux.CurrentRoute(request); route != nil {
+		if p, ok := rp.routes[route.GetName()]; ok {
+			return p
+		}
+		if tpl, err := route.GetPathTemplate(); err == nil {
+			if p, ok := rp.routes[request.Method+" "+tpl]; ok {
+				return p
+			}
+		}
+	}
+	return rp.routes[DefaultRoute]
+}
+
+// check makes sure every policy targets a route of router, so a typo doesn't
+// leave a route with the default limit.
+func (rp *RatePolicies) check(router *mux.Router) error {
+	known := map[string]bool{DefaultRoute: true}
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		known[route.GetName()] = true
+		tpl, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, _ := route.GetMethods()
+		for _, m := range methods {
+			known[m+" "+tpl] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for target := range rp.routes {
+		if !known[target] {
+			return fmt.Errorf("rate limit for unknown route %q", target)
+		}
+	}
+	return nil
+}
+
+// ipRateKey is the bucket of the client IP, the one from TrustedProxies when
+// there is one.
+func ipRateKey(request *http.Request) (string, error) {
+	if ip := ClientIPFromContext(request.Context()); ip != nil {
+		return "ip:" + ip.String(), nil
+	}
+	ip, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return "", err
+	}
+	return "ip:" + ip, nil
+}
+
+// principalRateKey is the bucket of a request whose credentials were
+// verified as principal, the IP one for anonymous requests.
+func principalRateKey(p RatePolicy, request *http.Request, principal *auth.Principal) (string, error) {
+	if principal == nil {
+		return ipRateKey(request)
+	}
+	if p.Key == RateKeyToken {
+		hash := sha256.Sum256([]byte(request.Header.Get("Authorization")))
+		return "token:" + hex.EncodeToString(hash[:]), nil
+	}
+	return "user:" + principal.ID().String(), nil
+}
+
+// limit takes a request from the bucket key of p and answers 429 when it is
+// empty, it reports whether the request may go on.
+func limit(writer http.ResponseWriter, request *http.Request, store LimiterStore, p RatePolicy, key string) bool {
+	res, err := store.Take(request.Context(), p.Route+"|"+key, p, time.Now())
+	if err != nil {
+		log.Printf("rate limit store: %v", err)
+		return true
+	}
+	setRateLimitHeaders(writer.Header(), p, res)
+	if !res.Allowed {
+		audit(request.Context(), "rate limit of %s exceeded", p.Route)
+		tooManyRequests(writer, res)
+		return false
+	}
+	return true
+}
+
+type deferredRateKey struct{}
+
+// deferredRate is the limit of a request whose policy is keyed by user or
+// token. The credentials aren't verified yet when RateLimit runs, Authorize
+// settles it once they are.
+type deferredRate struct {
+	policy  RatePolicy
+	store   LimiterStore
+	settled bool
+}
+
+// settleRateLimit takes a request with verified credentials from the bucket
+// of principal. It reports whether the request may go on.
+func settleRateLimit(writer http.ResponseWriter, request *http.Request, principal *auth.Principal) bool {
+	d, _ := request.Context().Value(deferredRateKey{}).(*deferredRate)
+	if d == nil || d.settled || principal == nil {
+		return true
+	}
+	d.settled = true
+	key, err := principalRateKey(d.policy, request, principal)
+	if err != nil {
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return false
+	}
+	return limit(writer, request, d.store, d.policy, key)
+}
+
+// authenticated reports whether the route of request goes through
+// Authenticate, only those can verify credentials.
+func authenticated(request *http.Request) bool {
+	route := mux.CurrentRoute(request)
+	if route == nil {
+		return false
+	}
+	a, ok := routeAccess[route.GetName()]
+	return ok && a.permission != ""
+}
+
+// RateLimit enforces policies on every request of the router it is used
+// on, the buckets live in store. Every request is first taken from the
+// bucket of its client IP, before any credentials are checked. Policies
+// keyed by user or token then also take requests whose credentials
+// Authorize verified from the bucket of the caller. Every response tells
+// the state of its bucket in the RateLimit headers of the IETF draft. When
+// the store fails the request goes through, an outage of a shared store
+// shouldn't take the API down with it.
+func RateLimit(policies *RatePolicies, store LimiterStore) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			p := policies.policy(request)
+			key, err := ipRateKey(request)
+			if err != nil {
+				http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			if !limit(writer, request, store, p, key) {
+				return
+			}
+			if p.Key != RateKeyIP && request.Header.Get("Authorization") != "" && authenticated(request) {
+				d := &deferredRate{policy: p, store: store}
+				request = request.WithContext(context.WithValue(request.Context(), deferredRateKey{}, d))
+			}
+			next.ServeHTTP(writer, request)
+		})
+	}
+}
+
+// seconds rounds d up to whole seconds for the headers.
+func seconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int(math.Ceil(d.Seconds()))
+}
+
+func setRateLimitHeaders(h http.Header, p RatePolicy, res RateResult) {
+	h.Set("RateLimit-Limit", strconv.Itoa(p.Burst))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(seconds(res.Reset)))
+}
+
+// tooManyRequests answers 429 with Retry-After and a JSON body clients can
+// back off by.
+func tooManyRequests(writer http.ResponseWriter, res RateResult) {
+	type responseBody struct {
+		Error      string `json:"error"`
+		RetryAfter int    `json:"retry_after"`
+	}
+	retry := seconds(res.RetryAfter)
+	if retry < 1 {
+		retry = 1
+	}
+	writer.Header().Set("Retry-After", strconv.Itoa(retry))
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(writer).Encode(responseBody{Error: "rate limit exceeded", RetryAfter: retry})
+}
@@ -0,0 +1,158 @@
+package server_test
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/pimka/go-onenote/server"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadRatePolicies(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "limits.json")
+	file := `[{"route":"POST /note/","rate":1,"burst":2},{"route":"*","rate":10,"burst":20,"key":"user"}]`
+	if err := os.WriteFile(path, []byte(file), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.LoadRatePolicies(server.Config{
+		RateLimitFile: path,
+		RateLimits:    []string{"POST /note/=0.5:1:token", "notes.get=20:40:ip:gcra"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range []string{
+		"notes.get",
+		"notes.get=fast:10",
+		"notes.get=5",
+		"notes.get=0:10",
+		"notes.get=5:0",
+		"notes.get=5:10:session",
+		"notes.get=5:10:ip:leaky",
+		"notes.get=1e300:1:ip:gcra",
+	} {
+		if _, err := server.LoadRatePolicies(server.Config{RateLimits: []string{entry}}); err == nil {
+			t.Errorf("rate limit %q accepted", entry)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	policies, err := server.NewRatePolicies(
+		server.RatePolicy{Route: "POST /note/", Rate: 0.001, Burst: 1},
+		server.RatePolicy{Route: "notes.get", Rate: 0.001, Burst: 2, Key: server.RateKeyUser},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := createUserServer()
+	for _, name := range []string{"pupa", "lupa", "kupa"} {
+		postCredentials(s.Register(), `{"username":"`+name+`","password":"lupa-pupa"}`)
+	}
+	ok := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {})
+	router := mux.NewRouter()
+	router.Use(server.RateLimit(policies, server.NewVLimiter(time.Minute)))
+	router.Handle("/note/", ok).Methods("POST").Name("notes.create")
+	router.Handle("/note/", ok).Methods("GET").Name("notes.list")
+	router.Handle("/note/{token}", s.Authorize("notes.get", ok)).Methods("GET").Name("notes.get")
+
+	do := func(method, path, user string, ip ...string) int {
+		req, _ := http.NewRequest(method, path, nil)
+		req.RemoteAddr = "10.0.0.1:4242"
+		if len(ip) > 0 {
+			req.RemoteAddr = ip[0] + ":4242"
+		}
+		if user != "" {
+			req.SetBasicAuth(user, "lupa-pupa")
+		}
+		if user == "nobody" {
+			req.SetBasicAuth(user, "guess")
+		}
+		respRecoder := httptest.NewRecorder()
+		router.ServeHTTP(respRecoder, req)
+		return respRecoder.Code
+	}
+
+	if code := do("POST", "/note/", ""); code != http.StatusOK {
+		t.Fatalf("first POST returned %d", code)
+	}
+	if code := do("POST", "/note/", ""); code != http.StatusTooManyRequests {
+		t.Errorf("POST went past its burst, %d", code)
+	}
+	for i := 0; i < 5; i++ {
+		if code := do("GET", "/note/", ""); code != http.StatusOK {
+			t.Fatalf("GET took the limit of POST, %d", code)
+		}
+	}
+
+	// a user is limited across IPs
+	for _, ip := range []string{"10.0.1.1", "10.0.1.2"} {
+		if code := do("GET", "/note/abc", "pupa", ip); code != http.StatusOK {
+			t.Fatalf("GET by pupa from %s returned %d", ip, code)
+		}
+	}
+	if code := do("GET", "/note/abc", "pupa", "10.0.1.3"); code != http.StatusTooManyRequests {
+		t.Errorf("user went past the burst, %d", code)
+	}
+
+	// and every request by its IP before the credentials are checked, so
+	// guesses can't get around it and a right one can't be told apart
+	for _, want := range []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests} {
+		if code := do("GET", "/note/abc", "nobody", "10.0.2.1"); code != want {
+			t.Errorf("GET with wrong credentials returned %d, want %d", code, want)
+		}
+	}
+	if code := do("GET", "/note/abc", "kupa", "10.0.2.1"); code != http.StatusTooManyRequests {
+		t.Errorf("right credentials got past the IP limit, %d", code)
+	}
+	if code := do("GET", "/note/abc", "lupa", "10.0.2.2"); code != http.StatusOK {
+		t.Errorf("GET from a fresh IP returned %d", code)
+	}
+}
+
+func TestRateLimit_Headers(t *testing.T) {
+	policies, _ := server.NewRatePolicies(server.RatePolicy{Route: server.DefaultRoute, Rate: 0.5, Burst: 2})
+	router := mux.NewRouter()
+	router.Use(server.RateLimit(policies, server.NewVLimiter(time.Minute)))
+	router.Handle("/note/", http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {})).Name("notes.list")
+
+	do := func() *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/note/", nil)
+		req.RemoteAddr = "10.0.0.1:4242"
+		respRecoder := httptest.NewRecorder()
+		router.ServeHTTP(respRecoder, req)
+		return respRecoder
+	}
+
+	for _, want := range []struct {
+		code      int
+		remaining string
+		reset     string
+	}{
+		{http.StatusOK, "1", "2"},
+		{http.StatusOK, "0", "4"},
+		{http.StatusTooManyRequests, "0", "4"},
+	} {
+		resp := do()
+		h := resp.Header()
+		if resp.Code != want.code || h.Get("RateLimit-Limit") != "2" ||
+			h.Get("RateLimit-Remaining") != want.remaining || h.Get("RateLimit-Reset") != want.reset {
+			t.Fatalf("got %d limit=%s remaining=%s reset=%s", resp.Code,
+				h.Get("RateLimit-Limit"), h.Get("RateLimit-Remaining"), h.Get("RateLimit-Reset"))
+		}
+		if resp.Code != http.StatusTooManyRequests {
+			continue
+		}
+		var body struct {
+			Error      string `json:"error"`
+			RetryAfter int    `json:"retry_after"`
+		}
+		if err := json.Unmarshal(resp.Body.Bytes(), &body); err != nil || body.RetryAfter != 2 || h.Get("Retry-After") != "2" {
+			t.Errorf("429 body %s, Retry-After %s", resp.Body, h.Get("Retry-After"))
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/pimka/go-onenote/auth"
+	"net/http"
+)
+
+type access struct {
+	// permission the caller needs, none for public routes
+	permission auth.Permission
+	// anonymous lets callers without credentials through, callers that do
+	// send credentials still need the permission
+	anonymous bool
+	// writes marks anonymous routes that change notes. Those stay closed to
+	// callers without credentials unless Server.AnonymousWrites is on, a
+	// reader could get around its role by leaving them out otherwise.
+	writes bool
+	// shareable lets a share link in the {token} path variable stand in for
+	// credentials
+	shareable bool
+}
+
+// routeAccess is the access policy of the API, keyed by route name. Every
+// route in Server.routes must have an entry here.
+var routeAccess = map[string]access{
+	"notes.list":   {permission: auth.PermNotesList},
+	"notes.create": {permission: auth.PermNotesCreate, anonymous: true, writes: true},
+	"notes.get":    {permission: auth.PermNotesGet, shareable: true},
+	"notes.update": {permission: auth.PermNotesUpdate, anonymous: true, writes: true, shareable: true},
+	"notes.delete": {permission: auth.PermNotesDelete},
+	"notes.pop":    {permission: auth.PermNotesGet, anonymous: true},
+	"notes.peek":   {permission: auth.PermNotesGet, anonymous: true},
+	"notes.ack":    {permission: auth.PermNotesGet, anonymous: true},
+
+	"shares.create": {permission: auth.PermNotesShare},
+	"shares.list":   {permission: auth.PermNotesShare},
+	"shares.revoke": {permission: auth.PermNotesShare},
+
+	"users.register": {},
+	"users.login":    {},
+	"tokens.create":  {permission: auth.PermTokensManage},
+	"tokens.list":    {permission: auth.PermTokensManage},
+	"tokens.revoke":  {permission: auth.PermTokensManage},
+
+	"admin.notes.list":  {permission: auth.PermNotesListAll},
+	"admin.notes.purge": {permission: auth.PermNotesPurge},
+	"admin.users.role":  {permission: auth.PermUsersManage},
+}
+
+// Authorize enforces the routeAccess entry of the route called name on next.
+// It panics for routes without an entry, so a route can't be added without
+// a policy.
+func (s *Server) Authorize(name string, next http.Handler) http.Handler {
+	a, ok := routeAccess[name]
+	if !ok {
+		panic(fmt.Sprintf("route %s has no access policy", name))
+	}
+	if a.permission == "" {
+		return next
+	}
+	anonymous := a.anonymous && (!a.writes || s.AnonymousWrites)
+
+	check := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		principal := auth.FromContext(request.Context())
+		if !settleRateLimit(writer, request, principal) {
+			return
+		}
+		if principal == nil && anonymous {
+			next.ServeHTTP(writer, request)
+			return
+		}
+		if !principal.Can(a.permission) {
+			audit(request.Context(), "%s refused", a.permission)
+			http.Error(writer, fmt.Sprintf("%s is not allowed", a.permission), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(writer, request)
+	})
+	guarded := s.authenticate(check)
+	if anonymous {
+		guarded = s.identify(check)
+	}
+	if !a.shareable {
+		return guarded
+	}
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		// the link is the capability, resolveNote checks it
+		if isShareToken(mux.Vars(request)["token"]) {
+			next.ServeHTTP(writer, request)
+			return
+		}
+		guarded.ServeHTTP(writer, request)
+	})
+}
+
+// handle registers handler under name behind its access policy.
+func (s *Server) handle(router *mux.Router, name, method, path string, handler http.Handler) {
+	router.Handle(path, s.Authorize(name, handler)).Methods(method).Name(name)
+}
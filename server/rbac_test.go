@@ -0,0 +1,108 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/pimka/go-onenote/auth"
+	"github.com/pimka/go-onenote/db"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServer_Authorize(t *testing.T) {
+	s := createUserServer()
+	ids := map[string]string{}
+	for _, role := range []string{auth.RoleAdmin, auth.RoleEditor, auth.RoleReader} {
+		resp := postCredentials(s.Register(), fmt.Sprintf(`{"username":%q,"password":"lupa-pupa"}`, role))
+		var user struct {
+			ID   string `json:"id"`
+			Role string `json:"role"`
+		}
+		json.Unmarshal(resp.Body.Bytes(), &user)
+		if user.Role != auth.RoleEditor {
+			t.Fatalf("Register gave the %s role", user.Role)
+		}
+		ids[role] = user.ID
+	}
+
+	router := mux.NewRouter()
+	router.Handle("/note/", s.Authorize("notes.list", s.ListNotes())).Methods("GET")
+	router.Handle("/note/", s.Authorize("notes.create", s.AddNote())).Methods("POST")
+	router.Handle("/admin/notes", s.Authorize("admin.notes.list", s.ListAllNotes())).Methods("GET")
+	router.Handle("/admin/notes/{id}", s.Authorize("admin.notes.purge", s.PurgeNote())).Methods("DELETE")
+	router.Handle("/admin/users/{id}/role", s.Authorize("admin.users.role", s.SetUserRole())).Methods("PUT")
+
+	do := func(user, method, path, body string) int {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		if user != "" {
+			req.SetBasicAuth(user, "lupa-pupa")
+		}
+		respRecoder := httptest.NewRecorder()
+		router.ServeHTTP(respRecoder, req)
+		return respRecoder.Code
+	}
+
+	if code := do(auth.RoleEditor, "PUT", "/admin/users/"+ids[auth.RoleAdmin]+"/role", `{"role":"admin"}`); code != http.StatusForbidden {
+		t.Fatalf("editor made an admin, %d", code)
+	}
+	s.Users.SetRole(context.Background(), uuid.FromStringOrNil(ids[auth.RoleAdmin]), auth.RoleAdmin)
+	if code := do(auth.RoleAdmin, "PUT", "/admin/users/"+ids[auth.RoleReader]+"/role", `{"role":"root"}`); code != http.StatusUnprocessableEntity {
+		t.Errorf("SetUserRole accepted an unknown role, %d", code)
+	}
+	if code := do(auth.RoleAdmin, "PUT", "/admin/users/"+ids[auth.RoleReader]+"/role", `{"role":"reader"}`); code != http.StatusNoContent {
+		t.Fatalf("SetUserRole returned %d", code)
+	}
+
+	mdb := s.NH.(*db.MockDB)
+	note := `{"text":"test","expiration":10}`
+	for _, c := range []struct {
+		user, method, path, body string
+		code                     int
+	}{
+		{"", "POST", "/note/", note, http.StatusUnauthorized},
+		{"", "GET", "/note/", "", http.StatusUnauthorized},
+		{"", "GET", "/admin/notes", "", http.StatusUnauthorized},
+		{auth.RoleReader, "POST", "/note/", note, http.StatusForbidden},
+		{auth.RoleReader, "GET", "/note/", "", http.StatusForbidden},
+		{auth.RoleReader, "GET", "/admin/notes", "", http.StatusForbidden},
+		{auth.RoleEditor, "POST", "/note/", note, http.StatusAccepted},
+		{auth.RoleEditor, "GET", "/note/", "", http.StatusAccepted},
+		{auth.RoleEditor, "GET", "/admin/notes", "", http.StatusForbidden},
+		{auth.RoleEditor, "DELETE", fmt.Sprintf("/admin/notes/%s", mdb.Notes[0].ID), "", http.StatusForbidden},
+		{auth.RoleAdmin, "GET", "/admin/notes", "", http.StatusAccepted},
+		{auth.RoleAdmin, "DELETE", fmt.Sprintf("/admin/notes/%s", mdb.Notes[0].ID), "", http.StatusNoContent},
+		{auth.RoleAdmin, "DELETE", "/admin/notes/garbage", "", http.StatusBadRequest},
+	} {
+		if code := do(c.user, c.method, c.path, c.body); code != c.code {
+			t.Errorf("%q %s %s returned %d, want %d", c.user, c.method, c.path, code, c.code)
+		}
+	}
+
+	// a reader leaving its credentials out is anonymous, so anonymous writes
+	// are only open when the server says so
+	s.AnonymousWrites = true
+	open := s.Authorize("notes.create", s.AddNote())
+	for user, want := range map[string]int{"": http.StatusAccepted, auth.RoleReader: http.StatusForbidden} {
+		req, _ := http.NewRequest("POST", "/note/", bytes.NewBufferString(note))
+		if user != "" {
+			req.SetBasicAuth(user, "lupa-pupa")
+		}
+		respRecoder := httptest.NewRecorder()
+		open.ServeHTTP(respRecoder, req)
+		if respRecoder.Code != want {
+			t.Errorf("%q POST /note/ with anonymous writes returned %d, want %d", user, respRecoder.Code, want)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Authorize accepted a route without a policy")
+		}
+	}()
+	s.Authorize("notes.secret", s.ListNotes())
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/gorilla/mux"
+	"github.com/pimka/go-onenote/auth"
 	"github.com/pimka/go-onenote/db"
 	"github.com/rs/cors"
 	"log"
@@ -14,29 +15,113 @@ import (
 )
 
 type Config struct {
-	Port             int      `env:"PORT" envDefault:"8080"`
-	AllowedOrigins   []string `env:"ALLOWED_ORIGINS" envSeparator:"," envDefault:"http://localhost:8000"`
-	AllowedMethods   []string `env:"ALLOWED_METHODS" envSeparator:"," envDefault:"GET,POST,PATCH,DELETE"`
-	AllowedHeaders   []string `env:"ALLOWED_HEADERS" envSeparator:"," envDefault:"Origin,X-Requested-With,Content-Type,Accept,Access-Control-Allow-Origin,Authorization"`
-	AllowCredentials bool     `env:"ALLOWED_CREDENTIALS" envDefault:"true"`
+	Port             int           `env:"PORT" envDefault:"8080"`
+	AllowedOrigins   []string      `env:"ALLOWED_ORIGINS" envSeparator:"," envDefault:"http://localhost:8000"`
+	AllowedMethods   []string      `env:"ALLOWED_METHODS" envSeparator:"," envDefault:"GET,POST,PATCH,DELETE"`
+	AllowedHeaders   []string      `env:"ALLOWED_HEADERS" envSeparator:"," envDefault:"Origin,X-Requested-With,Content-Type,Accept,Access-Control-Allow-Origin,Authorization"`
+	AllowCredentials bool          `env:"ALLOWED_CREDENTIALS" envDefault:"true"`
+	PopLease         time.Duration `env:"POP_LEASE" envDefault:"30s"`
+	E2EOnly          bool          `env:"E2E_ONLY" envDefault:"false"`
+	// PassphraseAttempts is how many wrong passphrases burn a note.
+	PassphraseAttempts int `env:"PASSPHRASE_MAX_ATTEMPTS" envDefault:"5"`
+	// AuthMode is "basic" for the users store or "jwt" for access tokens
+	// issued by an external identity provider.
+	AuthMode         string `env:"AUTH_MODE" envDefault:"basic"`
+	JWTSecret        string `env:"JWT_HS256_SECRET"`
+	JWKSFile         string `env:"JWT_JWKS_FILE"`
+	JWTIssuer        string `env:"JWT_ISSUER"`
+	JWTAudience      string `env:"JWT_AUDIENCE"`
+	JWTUsernameClaim string `env:"JWT_USERNAME_CLAIM" envDefault:"preferred_username"`
+	JWTScopeClaim    string `env:"JWT_SCOPE_CLAIM" envDefault:"scope"`
+	JWTRoleClaim     string `env:"JWT_ROLE_CLAIM" envDefault:"role"`
+	JWTDefaultRole   string `env:"JWT_DEFAULT_ROLE" envDefault:"reader"`
+	// DefaultRole is given to new users and to users from before roles.
+	DefaultRole string `env:"DEFAULT_ROLE" envDefault:"editor"`
+	// AnonymousWrites lets callers without credentials create and update
+	// notes, the one-time notes anyone can leave. Any role can do so then by
+	// leaving its credentials out, turn it off to make the roles binding for
+	// writes.
+	AnonymousWrites bool `env:"ANONYMOUS_WRITES" envDefault:"true"`
+	// ShareKey signs share links, base64. Without it links only last until
+	// the server restarts.
+	ShareKey string `env:"SHARE_LINK_KEY"`
+	// RateLimits are "<route>=<rate>:<burst>[:<key>[:<algorithm>]]"
+	// policies, see RatePolicy. They override the ones in RateLimitFile, a
+	// JSON list.
+	RateLimits    []string      `env:"RATE_LIMITS" envSeparator:";"`
+	RateLimitFile string        `env:"RATE_LIMIT_FILE"`
+	RateLimitIdle time.Duration `env:"RATE_LIMIT_IDLE" envDefault:"3m"`
+	// TrustedProxies are the CIDRs of the proxies whose forwarding headers
+	// tell the client IP.
+	TrustedProxies []string `env:"TRUSTED_PROXIES" envSeparator:","`
+	// TrustedProxyHeader is the forwarding header the trusted proxies write:
+	// Forwarded, X-Forwarded-For or X-Real-IP. Clients can send the others
+	// through the proxies unchanged, so they are never read.
+	TrustedProxyHeader string `env:"TRUSTED_PROXY_HEADER" envDefault:"X-Forwarded-For"`
+	// RateLimitStore is "memory" for limits per replica or "redis" to share
+	// them between replicas, redis only runs the gcra algorithm.
+	RateLimitStore    string `env:"RATE_LIMIT_STORE" envDefault:"memory"`
+	RateLimitRedisURL string `env:"RATE_LIMIT_REDIS_URL" envDefault:"redis://localhost:6379/0"`
 }
 
+const DefaultPopLease = time.Second * 30
+
 type Server struct {
 	Router   *mux.Router
 	NH       db.NoteHandler
+	Users    db.UserHandler
+	Tokens   db.TokenHandler
+	Shares   db.ShareHandler
 	DBPurger *db.NotePurger
 	VPurger  *VisitorsPurger
+	// PopLease is how long a popped note stays hidden waiting for its ack.
+	PopLease time.Duration
+	// E2EOnly refuses notes that don't come as an encrypted envelope.
+	E2EOnly            bool
+	PassphraseAttempts int
+	// Authenticator guards the routes that need a principal, Authenticate
+	// when nil.
+	Authenticator func(http.Handler) http.Handler
+	DefaultRole   string
+	// AnonymousWrites opens notes.create and notes.update to callers
+	// without credentials.
+	AnonymousWrites bool
+	// ShareKey signs share links.
+	ShareKey []byte
+	// RateLimits are the rate limit policies, LoadRatePolicies of the
+	// config when nil.
+	RateLimits *RatePolicies
+	Proxies    *TrustedProxies
+	// RateStore keeps the rate limit buckets, NewLimiterStore of the config
+	// when nil.
+	RateStore LimiterStore
 }
 
-func (s *Server) routes(vl *VLimiter) {
+func (s *Server) routes() {
 	noteRouter := s.Router.PathPrefix("/note/").Subrouter()
-	noteRouter.Handle("/", Limiter(s.ListNotes(), vl)).Methods("GET")
-	noteRouter.Handle("/", Limiter(s.AddNote(), vl)).Methods("POST")
-	noteRouter.Handle("/{uid}", Limiter(SimpleAuth(s.GetNote()), vl)).Methods("GET")
-	noteRouter.Handle("/{uid}", Limiter(s.UpdateNote(), vl)).Methods("PATCH")
-	noteRouter.Handle("/{uid}", Limiter(SimpleAuth(s.DeleteNote()), vl)).Methods("DELETE")
-	noteRouter.Handle("/api/", Limiter(s.PopNote(), vl)).Methods("DELETE")
-	noteRouter.Handle("/api/", Limiter(s.PeekNote(), vl)).Methods("GET")
+	s.handle(noteRouter, "notes.list", "GET", "/", s.ListNotes())
+	s.handle(noteRouter, "notes.create", "POST", "/", s.AddNote())
+	s.handle(noteRouter, "notes.get", "GET", "/{token}", s.GetNote())
+	s.handle(noteRouter, "notes.update", "PATCH", "/{token}", s.UpdateNote())
+	s.handle(noteRouter, "notes.delete", "DELETE", "/{token}", s.DeleteNote())
+	s.handle(noteRouter, "shares.create", "POST", "/{token}/share", s.CreateShare())
+	s.handle(noteRouter, "shares.list", "GET", "/{token}/share", s.ListShares())
+	s.handle(noteRouter, "shares.revoke", "DELETE", "/{token}/share/{id}", s.RevokeShare())
+	s.handle(noteRouter, "notes.pop", "DELETE", "/api/", s.PopNote())
+	s.handle(noteRouter, "notes.peek", "GET", "/api/", s.PeekNote())
+	s.handle(noteRouter, "notes.ack", "POST", "/api/ack", s.AckNote())
+
+	userRouter := s.Router.PathPrefix("/user/").Subrouter()
+	s.handle(userRouter, "users.register", "POST", "/register", s.Register())
+	s.handle(userRouter, "users.login", "POST", "/login", s.Login())
+	s.handle(userRouter, "tokens.create", "POST", "/tokens", s.CreateToken())
+	s.handle(userRouter, "tokens.list", "GET", "/tokens", s.ListTokens())
+	s.handle(userRouter, "tokens.revoke", "DELETE", "/tokens/{id}", s.RevokeToken())
+
+	adminRouter := s.Router.PathPrefix("/admin/").Subrouter()
+	s.handle(adminRouter, "admin.notes.list", "GET", "/notes", s.ListAllNotes())
+	s.handle(adminRouter, "admin.notes.purge", "DELETE", "/notes/{id}", s.PurgeNote())
+	s.handle(adminRouter, "admin.users.role", "PUT", "/users/{id}/role", s.SetUserRole())
 }
 
 func setContentType(next http.Handler) http.Handler {
@@ -46,7 +131,9 @@ func setContentType(next http.Handler) http.Handler {
 	})
 }
 
-func (s *Server) Start(c Config) {
+// Handler sets s up from c and returns its API behind CORS, with the
+// middlewares and routes Start serves.
+func (s *Server) Handler(c Config) (http.Handler, error) {
 	cors := cors.New(cors.Options{
 		AllowedOrigins:   c.AllowedOrigins,
 		AllowedMethods:   c.AllowedMethods,
@@ -54,14 +141,81 @@ func (s *Server) Start(c Config) {
 		AllowCredentials: c.AllowCredentials,
 	})
 
-	s.Router.Use(setContentType)
-	s.routes(&s.VPurger.limiter)
+	s.PopLease = c.PopLease
+	s.E2EOnly = c.E2EOnly
+	s.PassphraseAttempts = c.PassphraseAttempts
+	if !auth.ValidRole(c.DefaultRole) {
+		return nil, fmt.Errorf("unknown default role %q", c.DefaultRole)
+	}
+	s.DefaultRole = c.DefaultRole
+	s.AnonymousWrites = c.AnonymousWrites
+	if s.ShareKey == nil {
+		if c.ShareKey == "" {
+			log.Println("SHARE_LINK_KEY is not set, share links won't survive a restart")
+		}
+		key, err := NewShareKey(c.ShareKey)
+		if err != nil {
+			return nil, fmt.Errorf("could not set up share links: %w", err)
+		}
+		s.ShareKey = key
+	}
+	if s.Authenticator == nil {
+		authenticator, err := NewAuthenticator(c)
+		if err != nil {
+			return nil, fmt.Errorf("could not set up authentication: %w", err)
+		}
+		s.Authenticator = authenticator
+	}
+	if s.RateLimits == nil {
+		policies, err := LoadRatePolicies(c)
+		if err != nil {
+			return nil, fmt.Errorf("could not load rate limits: %w", err)
+		}
+		s.RateLimits = policies
+	}
+	if s.Proxies == nil {
+		proxies, err := NewTrustedProxies(c.TrustedProxyHeader, c.TrustedProxies)
+		if err != nil {
+			return nil, err
+		}
+		s.Proxies = proxies
+	}
+	if s.RateStore == nil {
+		vl := NewVLimiter(c.RateLimitIdle)
+		if s.VPurger != nil {
+			vl = s.VPurger.limiter
+		}
+		store, err := NewLimiterStore(c, vl)
+		if err != nil {
+			return nil, fmt.Errorf("could not set up the rate limit store: %w", err)
+		}
+		s.RateStore = store
+	}
+	if err := s.RateLimits.checkStore(s.RateStore); err != nil {
+		return nil, err
+	}
+	if s.Router == nil {
+		s.Router = mux.NewRouter()
+	}
+	s.Router.Use(setContentType, s.Proxies.Middleware, RateLimit(s.RateLimits, s.RateStore))
+	s.routes()
+	if err := s.RateLimits.check(s.Router); err != nil {
+		return nil, err
+	}
+	return cors.Handler(s.Router), nil
+}
+
+func (s *Server) Start(c Config) {
+	handler, err := s.Handler(c)
+	if err != nil {
+		log.Fatal(err)
+	}
 	server := &http.Server{
 		Addr:         fmt.Sprintf(":%d", c.Port),
 		WriteTimeout: time.Second * 15,
 		ReadTimeout:  time.Second * 15,
 		IdleTimeout:  time.Second * 60,
-		Handler:      cors.Handler(s.Router),
+		Handler:      handler,
 	}
 
 	go func() {
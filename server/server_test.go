@@ -4,11 +4,15 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"github.com/gofrs/uuid"
 	"github.com/gorilla/mux"
+	"github.com/pimka/go-onenote/auth"
 	"github.com/pimka/go-onenote/db"
+	"github.com/pimka/go-onenote/secret"
 	"github.com/pimka/go-onenote/server"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 )
@@ -22,6 +26,40 @@ func createServer(mdb *db.MockDB) *server.Server {
 	return s
 }
 
+// addNote creates a note through AddNote and returns its access token along
+// with the note as the mock stores it.
+func addNote(t *testing.T, s *server.Server, mdb *db.MockDB, body string) (string, *db.Note) {
+	req, err := http.NewRequest("POST", "/note/", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	respRecoder := httptest.NewRecorder()
+	s.AddNote().ServeHTTP(respRecoder, req)
+	if respRecoder.Code != http.StatusAccepted {
+		t.Fatalf("AddNote returned %d", respRecoder.Code)
+	}
+	var created struct {
+		Token string `json:"token"`
+	}
+	if err = json.Unmarshal(respRecoder.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	return created.Token, mdb.Notes[len(mdb.Notes)-1]
+}
+
+func getNote(s *server.Server, token, pass string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/note/%s", token), nil)
+	if pass != "" {
+		req.Header.Set(server.PassphraseHeader, pass)
+	}
+	req = mux.SetURLVars(req, map[string]string{
+		"token": token,
+	})
+	respRecoder := httptest.NewRecorder()
+	s.GetNote().ServeHTTP(respRecoder, req)
+	return respRecoder
+}
+
 func TestServer_ListNotes(t *testing.T) {
 	mdb := db.NewMockDB()
 	s := createServer(mdb)
@@ -60,29 +98,73 @@ func TestServer_AddNote(t *testing.T) {
 	}
 }
 
+func TestServer_AddNoteE2EOnly(t *testing.T) {
+	mbd := db.NewMockDB()
+	s := createServer(mbd)
+	s.E2EOnly = true
+
+	for _, c := range []struct {
+		note db.Note
+		code int
+	}{
+		{db.Note{Text: "plain", Expiration: 100}, http.StatusUnprocessableEntity},
+		{db.Note{Text: "c2VjcmV0", Nonce: "bm9uY2U=", Algorithm: server.AlgorithmAES256GCM, Expiration: 100}, http.StatusUnprocessableEntity},
+		{db.Note{Text: "c2VjcmV0", Nonce: "AAAAAAAAAAAAAAAA", Algorithm: "ROT13", Expiration: 100}, http.StatusUnprocessableEntity},
+		{db.Note{Text: "AAAAAAAAAAAAAAAAAAAAAAAAAAAA", Nonce: "AAAAAAAAAAAAAAAA", Algorithm: server.AlgorithmAES256GCM, Expiration: 100}, http.StatusAccepted},
+	} {
+		noteJson, err := json.Marshal(c.note)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest("POST", "/note/", bytes.NewBuffer(noteJson))
+		if err != nil {
+			t.Fatal(err)
+		}
+		respRecoder := httptest.NewRecorder()
+		s.AddNote().ServeHTTP(respRecoder, req)
+		if respRecoder.Code != c.code {
+			t.Errorf("AddNote %+v: got %d, want %d", c.note, respRecoder.Code, c.code)
+		}
+	}
+
+	stored := mbd.Notes[len(mbd.Notes)-1]
+	if stored.Nonce != "AAAAAAAAAAAAAAAA" || stored.Algorithm != server.AlgorithmAES256GCM {
+		t.Error("AddNote dropped the envelope")
+	}
+}
+
 func TestServer_GetNote(t *testing.T) {
 	mbd := db.NewMockDB()
 	s := createServer(mbd)
-	uid := mbd.Notes[0].ID
-	req, err := http.NewRequest("GET", fmt.Sprintf("/note/%s", uid), nil)
-	if err != nil {
-		t.Fatal(err)
+	token, stored := addNote(t, s, mbd, `{"text":"test","expiration":10}`)
+	if stored.Text == "test" {
+		t.Fatal("AddNote stored the text in plaintext")
+	}
+	if strings.Contains(stored.ID.String()+stored.Text, token) {
+		t.Fatal("AddNote stored the access token")
 	}
 
-	req = mux.SetURLVars(req, map[string]string{
-		"uid": uid.String(),
-	})
-	respRecoder := httptest.NewRecorder()
-	s.GetNote().ServeHTTP(respRecoder, req)
+	respRecoder := getNote(s, token, "")
 	if respRecoder.Code != http.StatusOK {
 		t.Error("Server error on GetNote")
 	}
+	var note db.Note
+	if err := json.Unmarshal(respRecoder.Body.Bytes(), &note); err != nil {
+		t.Fatal(err)
+	}
+	if note.Text != "test" {
+		t.Error("GetNote didn't decrypt the note")
+	}
+
+	if respRecoder = getNote(s, stored.ID.String(), ""); respRecoder.Code != http.StatusBadRequest {
+		t.Error("GetNote accepted the note ID as a token")
+	}
 }
 
 func TestServer_UpdateNote(t *testing.T) {
 	mbd := db.NewMockDB()
 	s := createServer(mbd)
-	note := mbd.Notes[0]
+	token, _ := addNote(t, s, mbd, `{"text":"test","expiration":10}`)
 	newNote := db.Note{
 		Text: "new test",
 	}
@@ -90,20 +172,25 @@ func TestServer_UpdateNote(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	req, err := http.NewRequest("PATCH", fmt.Sprintf("/note/%s", note.ID), bytes.NewBuffer(noteJson))
+	req, err := http.NewRequest("PATCH", fmt.Sprintf("/note/%s", token), bytes.NewBuffer(noteJson))
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	req = mux.SetURLVars(req, map[string]string{
-		"uid": note.ID.String(),
+		"token": token,
 	})
 	respRecoder := httptest.NewRecorder()
 	s.UpdateNote().ServeHTTP(respRecoder, req)
 	if respRecoder.Code != http.StatusAccepted {
 		t.Error("Server error on UpdateNote")
 	}
-	if mbd.Notes[0].Text != newNote.Text {
+
+	var note db.Note
+	if err = json.Unmarshal(getNote(s, token, "").Body.Bytes(), &note); err != nil {
+		t.Fatal(err)
+	}
+	if note.Text != newNote.Text {
 		t.Error("Doesnt updated")
 	}
 }
@@ -111,21 +198,21 @@ func TestServer_UpdateNote(t *testing.T) {
 func TestServer_DeleteNote(t *testing.T) {
 	mbd := db.NewMockDB()
 	s := createServer(mbd)
-	uid := mbd.Notes[0].ID
-	req, err := http.NewRequest("DELETE", fmt.Sprintf("/note/%s", uid), nil)
+	token, stored := addNote(t, s, mbd, `{"text":"test","expiration":10}`)
+	req, err := http.NewRequest("DELETE", fmt.Sprintf("/note/%s", token), nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	req = mux.SetURLVars(req, map[string]string{
-		"uid": uid.String(),
+		"token": token,
 	})
 	respRecoder := httptest.NewRecorder()
 	s.DeleteNote().ServeHTTP(respRecoder, req)
 	if respRecoder.Code != http.StatusNoContent {
 		t.Error("Server error on DeleteNote")
 	}
-	if uid == mbd.Notes[0].ID {
+	if n, _ := mbd.Get(req.Context(), stored.ID); n != nil {
 		t.Error("Doesnt deleted")
 	}
 }
@@ -133,16 +220,10 @@ func TestServer_DeleteNote(t *testing.T) {
 func TestServer_PopNote(t *testing.T) {
 	mbd := db.NewMockDB()
 	s := createServer(mbd)
-	uid := mbd.Notes[0].ID
-	note := db.Note{
-		ID: uid,
-	}
-	noteJson, err := json.Marshal(note)
-	if err != nil {
-		t.Fatal(err)
-	}
+	token, stored := addNote(t, s, mbd, `{"text":"test","expiration":10}`)
+	noteJson := fmt.Sprintf(`{"token":%q}`, token)
 
-	req, err := http.NewRequest("DELETE", "/note/api", bytes.NewBuffer(noteJson))
+	req, err := http.NewRequest("DELETE", "/note/api", bytes.NewBufferString(noteJson))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -150,9 +231,40 @@ func TestServer_PopNote(t *testing.T) {
 	respRecoder := httptest.NewRecorder()
 	s.PopNote().ServeHTTP(respRecoder, req)
 	if respRecoder.Code != http.StatusOK {
-		t.Error("Server error on DeleteNote")
+		t.Error("Server error on PopNote")
+	}
+	var popped struct {
+		Text       string `json:"text"`
+		LeaseToken string `json:"lease_token"`
+	}
+	if err = json.Unmarshal(respRecoder.Body.Bytes(), &popped); err != nil {
+		t.Fatal(err)
+	}
+	if popped.Text != "test" {
+		t.Error("PopNote didn't decrypt the note")
+	}
+
+	req, err = http.NewRequest("DELETE", "/note/api", bytes.NewBufferString(noteJson))
+	if err != nil {
+		t.Fatal(err)
+	}
+	respRecoder = httptest.NewRecorder()
+	s.PopNote().ServeHTTP(respRecoder, req)
+	if respRecoder.Code != http.StatusNotFound {
+		t.Error("Reserved note popped twice")
+	}
+
+	ack := fmt.Sprintf(`{"id":"%s","lease_token":"%s"}`, stored.ID, popped.LeaseToken)
+	req, err = http.NewRequest("POST", "/note/api/ack", bytes.NewBufferString(ack))
+	if err != nil {
+		t.Fatal(err)
+	}
+	respRecoder = httptest.NewRecorder()
+	s.AckNote().ServeHTTP(respRecoder, req)
+	if respRecoder.Code != http.StatusNoContent {
+		t.Error("Server error on AckNote")
 	}
-	if uid == mbd.Notes[0].ID {
+	if stored == mbd.Notes[len(mbd.Notes)-1] {
 		t.Error("Doesnt pop")
 	}
 }
@@ -160,23 +272,253 @@ func TestServer_PopNote(t *testing.T) {
 func TestServer_PeekNote(t *testing.T) {
 	mbd := db.NewMockDB()
 	s := createServer(mbd)
-	uid := mbd.Notes[0].ID
-	note := db.Note{
-		ID: uid,
+	token, _ := addNote(t, s, mbd, `{"text":"test","expiration":10}`)
+
+	req, err := http.NewRequest("GET", "/note/api", bytes.NewBufferString(fmt.Sprintf(`{"token":%q}`, token)))
+	if err != nil {
+		t.Fatal(err)
 	}
-	noteJson, err := json.Marshal(note)
+
+	respRecoder := httptest.NewRecorder()
+	s.PeekNote().ServeHTTP(respRecoder, req)
+	if respRecoder.Code != http.StatusOK {
+		t.Error("Server error on DeleteNote")
+	}
+}
+
+func TestServer_ListNotesPages(t *testing.T) {
+	mdb := db.NewMockDB()
+	s := createServer(mdb)
+
+	req, err := http.NewRequest("GET", "/note/?limit=15", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	respRecoder := httptest.NewRecorder()
+	s.ListNotes().ServeHTTP(respRecoder, req)
+	if respRecoder.Code != http.StatusAccepted {
+		t.Fatal("Server error on ListNotes")
+	}
+
+	var page struct {
+		Notes      []*db.Note `json:"notes"`
+		NextCursor string     `json:"next_cursor"`
+	}
+	if err = json.Unmarshal(respRecoder.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Notes) != 15 || page.NextCursor == "" {
+		t.Fatal("ListNotes ignored limit")
+	}
+	for _, n := range page.Notes {
+		if n.Text != "" {
+			t.Fatal("ListNotes returned the text of a note")
+		}
+	}
+
+	req, err = http.NewRequest("GET", "/note/?cursor="+page.NextCursor, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
+	respRecoder = httptest.NewRecorder()
+	s.ListNotes().ServeHTTP(respRecoder, req)
+	if err = json.Unmarshal(respRecoder.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Notes) != 5 || page.NextCursor != "" {
+		t.Error("ListNotes returned a wrong last page")
+	}
 
-	req, err := http.NewRequest("GET", "/note/api", bytes.NewBuffer(noteJson))
+	req, err = http.NewRequest("GET", "/note/?limit=abc", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
+	respRecoder = httptest.NewRecorder()
+	s.ListNotes().ServeHTTP(respRecoder, req)
+	if respRecoder.Code != http.StatusBadRequest {
+		t.Error("ListNotes accepted an invalid limit")
+	}
+}
+
+func TestServer_GetExpiredNote(t *testing.T) {
+	mbd := db.NewMockDB()
+	s := createServer(mbd)
+	token, note := addNote(t, s, mbd, `{"text":"test","expiration":10}`)
+	note.Created = note.Created.Add(-time.Hour)
+
+	respRecoder := getNote(s, token, "")
+	if respRecoder.Code != http.StatusNotFound {
+		t.Error("GetNote returned an expired note")
+	}
+}
 
+func TestServer_GetNoteMaxViews(t *testing.T) {
+	mbd := db.NewMockDB()
+	s := createServer(mbd)
+
+	token, note := addNote(t, s, mbd, `{"text":"test","expiration":10,"max_views":1}`)
+	if note.ViewsRemaining == nil || *note.ViewsRemaining != 1 {
+		t.Fatal("AddNote ignored max_views")
+	}
+
+	for _, code := range []int{http.StatusOK, http.StatusNotFound} {
+		respRecoder := getNote(s, token, "")
+		if respRecoder.Code != code {
+			t.Fatalf("GetNote returned %d, want %d", respRecoder.Code, code)
+		}
+	}
+}
+
+func TestServer_GetNotePassphrase(t *testing.T) {
+	mbd := db.NewMockDB()
+	s := createServer(mbd)
+	s.PassphraseAttempts = 2
+
+	req, err := http.NewRequest("POST", "/note/", bytes.NewBufferString(`{"text":"test","expiration":10,"passphrase":"open sesame"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
 	respRecoder := httptest.NewRecorder()
-	s.PeekNote().ServeHTTP(respRecoder, req)
-	if respRecoder.Code != http.StatusOK {
-		t.Error("Server error on DeleteNote")
+	s.AddNote().ServeHTTP(respRecoder, req)
+	var note struct {
+		db.Note
+		Token string `json:"token"`
+	}
+	if err = json.Unmarshal(respRecoder.Body.Bytes(), &note); err != nil {
+		t.Fatal(err)
+	}
+	if note.PassHash != "" {
+		t.Fatal("AddNote returned the passphrase hash")
+	}
+	stored, _ := mbd.Get(req.Context(), note.ID)
+	if stored == nil || stored.Text == "test" || stored.PassHash == "" {
+		t.Fatal("AddNote stored the text in plaintext")
+	}
+
+	if resp := getNote(s, note.Token, "sesame open"); resp.Code != http.StatusForbidden {
+		t.Fatalf("GetNote with a wrong passphrase returned %d", resp.Code)
+	}
+	resp := getNote(s, note.Token, "open sesame")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("GetNote with the passphrase returned %d", resp.Code)
+	}
+	var got db.Note
+	if err = json.Unmarshal(resp.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Text != "test" {
+		t.Fatal("GetNote didn't decrypt the note")
+	}
+
+	if resp = getNote(s, note.Token, ""); resp.Code != http.StatusForbidden {
+		t.Fatalf("GetNote without a passphrase returned %d", resp.Code)
+	}
+	if resp = getNote(s, note.Token, "open sesame"); resp.Code != http.StatusNotFound {
+		t.Fatal("note survived running out of attempts")
+	}
+}
+
+func TestServer_PopNotePassphrase(t *testing.T) {
+	mbd := db.NewMockDB()
+	s := createServer(mbd)
+	token, _ := addNote(t, s, mbd, `{"text":"test","expiration":10,"passphrase":"open sesame"}`)
+
+	var respRecoder *httptest.ResponseRecorder
+	for _, c := range []struct {
+		pass string
+		code int
+	}{
+		{"wrong", http.StatusForbidden},
+		{"open sesame", http.StatusOK},
+	} {
+		body := fmt.Sprintf(`{"token":%q,"passphrase":%q}`, token, c.pass)
+		req, err := http.NewRequest("DELETE", "/note/api/", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		respRecoder = httptest.NewRecorder()
+		s.PopNote().ServeHTTP(respRecoder, req)
+		if respRecoder.Code != c.code {
+			t.Fatalf("PopNote returned %d, want %d", respRecoder.Code, c.code)
+		}
+	}
+	var popped db.Note
+	if err := json.Unmarshal(respRecoder.Body.Bytes(), &popped); err != nil {
+		t.Fatal(err)
+	}
+	if popped.Text != "test" {
+		t.Fatal("PopNote didn't decrypt the note")
+	}
+}
+
+func TestServer_NoteOwners(t *testing.T) {
+	mdb := db.NewMockDB()
+	s := createServer(mdb)
+	pupa := &auth.Principal{UserID: uuid.Must(uuid.NewV4()), Username: "pupa"}
+	lupa := &auth.Principal{UserID: uuid.Must(uuid.NewV4()), Username: "lupa"}
+
+	do := func(handler http.Handler, p *auth.Principal, method, token, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, "/note/"+token, bytes.NewBufferString(body))
+		if p != nil {
+			req = req.WithContext(auth.WithPrincipal(req.Context(), p))
+		}
+		req = mux.SetURLVars(req, map[string]string{"token": token})
+		respRecoder := httptest.NewRecorder()
+		handler.ServeHTTP(respRecoder, req)
+		return respRecoder
+	}
+
+	resp := do(s.AddNote(), pupa, "POST", "", `{"text":"pupa's","expiration":10}`)
+	if resp.Code != http.StatusAccepted {
+		t.Fatalf("AddNote returned %d", resp.Code)
+	}
+	var created struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if stored := mdb.Notes[len(mdb.Notes)-1]; stored.OwnerID != pupa.UserID {
+		t.Fatal("AddNote didn't record the owner")
+	}
+
+	var listed struct {
+		Notes []*db.Note `json:"notes"`
+	}
+	json.Unmarshal(do(s.ListNotes(), pupa, "GET", "", "").Body.Bytes(), &listed)
+	if len(listed.Notes) != 1 || listed.Notes[0].OwnerID != pupa.UserID {
+		t.Fatalf("ListNotes returned %d notes to the owner", len(listed.Notes))
+	}
+	json.Unmarshal(do(s.ListNotes(), lupa, "GET", "", "").Body.Bytes(), &listed)
+	if len(listed.Notes) != 0 {
+		t.Fatal("ListNotes returned another user's notes")
+	}
+
+	for _, p := range []*auth.Principal{lupa, nil} {
+		if resp = do(s.UpdateNote(), p, "PATCH", created.Token, `{"text":"mine"}`); resp.Code != http.StatusNotFound {
+			t.Errorf("UpdateNote by a stranger returned %d", resp.Code)
+		}
+	}
+	if resp = do(s.DeleteNote(), lupa, "DELETE", created.Token, ""); resp.Code != http.StatusNotFound {
+		t.Errorf("DeleteNote by a stranger returned %d", resp.Code)
+	}
+	if resp = getNote(s, created.Token, ""); resp.Code != http.StatusOK {
+		t.Errorf("GetNote with the token returned %d", resp.Code)
+	}
+	// the token is what lets a note be read, whoever holds it
+	for _, p := range []*auth.Principal{lupa, nil} {
+		if resp = do(s.GetNote(), p, "GET", created.Token, ""); resp.Code != http.StatusOK {
+			t.Errorf("GetNote with the token by a stranger returned %d", resp.Code)
+		}
+	}
+	guessed, _ := secret.NewToken()
+	if resp = do(s.GetNote(), pupa, "GET", guessed, ""); resp.Code != http.StatusNotFound {
+		t.Errorf("GetNote by the owner without the token returned %d", resp.Code)
+	}
+	if resp = do(s.UpdateNote(), pupa, "PATCH", created.Token, `{"text":"still pupa's"}`); resp.Code != http.StatusAccepted {
+		t.Errorf("UpdateNote by the owner returned %d", resp.Code)
+	}
+	if resp = do(s.DeleteNote(), pupa, "DELETE", created.Token, ""); resp.Code != http.StatusNoContent {
+		t.Errorf("DeleteNote by the owner returned %d", resp.Code)
 	}
 }
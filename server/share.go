@@ -0,0 +1,348 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/pimka/go-onenote/auth"
+	"github.com/pimka/go-onenote/db"
+	"github.com/pimka/go-onenote/secret"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ShareTokenPrefix marks share links, they go where a note token would.
+const ShareTokenPrefix = "ons_"
+
+const shareKeySize = 32
+
+var errInvalidShareToken = errors.New("invalid share link")
+
+const shareKeyInfo = "onenote share link"
+
+// shareClaims is the signed payload of a share link. It carries neither the
+// note token nor the key the note text is sealed with, only a secret of the
+// link that opens the key kept in the link record. A link can't be turned
+// into full access to its note and stops working for good once revoked.
+type shareClaims struct {
+	LinkID     uuid.UUID `json:"lid"`
+	NoteID     uuid.UUID `json:"nid"`
+	Permission string    `json:"perm"`
+	ExpiresAt  int64     `json:"exp"`
+	Secret     string    `json:"s"`
+}
+
+// NewShareKey decodes the base64 key share links are signed with, an empty
+// key gets a random one that only lasts until the server restarts.
+func NewShareKey(encoded string) ([]byte, error) {
+	if encoded == "" {
+		key := make([]byte, shareKeySize)
+		_, err := rand.Read(key)
+		return key, err
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) < shareKeySize {
+		return nil, fmt.Errorf("share link key must be at least %d bytes", shareKeySize)
+	}
+	return key, nil
+}
+
+// wrapShareKey seals the text key of a note under the key derived from a link
+// secret.
+func wrapShareKey(linkSecret string, tokenKey []byte) (string, error) {
+	key, err := secret.TokenKey(linkSecret, shareKeyInfo)
+	if err != nil {
+		return "", err
+	}
+	return secret.Seal(key, base64.StdEncoding.EncodeToString(tokenKey))
+}
+
+func unwrapShareKey(linkSecret, wrapped string) ([]byte, error) {
+	key, err := secret.TokenKey(linkSecret, shareKeyInfo)
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := secret.Open(key, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(encoded)
+}
+
+// publicShare strips the wrapped key, it is of no use outside the server.
+func publicShare(l *db.ShareLink) *db.ShareLink {
+	c := *l
+	c.WrappedKey = ""
+	return &c
+}
+
+func isShareToken(token string) bool {
+	return strings.HasPrefix(token, ShareTokenPrefix)
+}
+
+func (s *Server) signShareToken(c shareClaims) (string, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	mac := hmac.New(sha256.New, s.ShareKey)
+	mac.Write([]byte(payload))
+	return ShareTokenPrefix + payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// parseShareToken checks the signature and expiry of a share link.
+func (s *Server) parseShareToken(token string) (*shareClaims, error) {
+	payload, sig, ok := strings.Cut(strings.TrimPrefix(token, ShareTokenPrefix), ".")
+	if !ok || len(s.ShareKey) == 0 {
+		return nil, errInvalidShareToken
+	}
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return nil, errInvalidShareToken
+	}
+	mac := hmac.New(sha256.New, s.ShareKey)
+	mac.Write([]byte(payload))
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return nil, errInvalidShareToken
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, errInvalidShareToken
+	}
+	c := &shareClaims{}
+	if err = json.Unmarshal(data, c); err != nil {
+		return nil, errInvalidShareToken
+	}
+	if !time.Unix(c.ExpiresAt, 0).After(time.Now()) {
+		return nil, errInvalidShareToken
+	}
+	return c, nil
+}
+
+// resolveNote turns the token of a note route into the note ID and text
+// key. A share link also needs a live record that grants perm, the link is
+// returned so the caller can count the view. On failure it answers the
+// request itself.
+func (s *Server) resolveNote(ctx context.Context, writer http.ResponseWriter, token string, perm string) (uuid.UUID, []byte, *db.ShareLink, bool) {
+	if !isShareToken(token) {
+		uid, tokenKey, err := noteToken(token)
+		if err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			return uuid.Nil, nil, nil, false
+		}
+		return uid, tokenKey, nil, true
+	}
+
+	c, err := s.parseShareToken(token)
+	if err != nil {
+		writer.WriteHeader(http.StatusNotFound)
+		return uuid.Nil, nil, nil, false
+	}
+	link, err := s.Shares.GetShare(ctx, c.LinkID)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		return uuid.Nil, nil, nil, false
+	}
+	if link == nil || link.NoteID != c.NoteID {
+		writer.WriteHeader(http.StatusNotFound)
+		return uuid.Nil, nil, nil, false
+	}
+	if perm == db.SharePermReadWrite && link.Permission != db.SharePermReadWrite {
+		http.Error(writer, "share link is read only", http.StatusForbidden)
+		return uuid.Nil, nil, nil, false
+	}
+	tokenKey, err := unwrapShareKey(c.Secret, link.WrappedKey)
+	if err != nil {
+		writer.WriteHeader(http.StatusNotFound)
+		return uuid.Nil, nil, nil, false
+	}
+	return link.NoteID, tokenKey, link, true
+}
+
+// sharedNote loads the note of a share route, only its owner may manage
+// its links.
+func (s *Server) sharedNote(writer http.ResponseWriter, request *http.Request) (*db.Note, []byte, bool) {
+	uid, tokenKey, err := noteToken(mux.Vars(request)["token"])
+	if err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return nil, nil, false
+	}
+	ctx := request.Context()
+	note, err := s.NH.Get(ctx, uid)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	if note == nil || note.OwnerID != auth.FromContext(ctx).ID() {
+		writer.WriteHeader(http.StatusNotFound)
+		return nil, nil, false
+	}
+	return note, tokenKey, true
+}
+
+func (s *Server) CreateShare() http.HandlerFunc {
+	type requestBody struct {
+		Permission string `json:"permission"`
+		ExpiresIn  string `json:"expires_in"`
+		MaxViews   int    `json:"max_views"`
+	}
+	type responseBody struct {
+		*db.ShareLink
+		Token string `json:"token"`
+	}
+	return func(writer http.ResponseWriter, request *http.Request) {
+		var r requestBody
+		bytes, err := ioutil.ReadAll(request.Body)
+		if err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if len(bytes) > 0 {
+			if err = json.Unmarshal(bytes, &r); err != nil {
+				http.Error(writer, err.Error(), http.StatusUnprocessableEntity)
+				return
+			}
+		}
+		if r.Permission == "" {
+			r.Permission = db.SharePermRead
+		}
+		if r.Permission != db.SharePermRead && r.Permission != db.SharePermReadWrite {
+			http.Error(writer, fmt.Sprintf("unknown permission %q", r.Permission), http.StatusUnprocessableEntity)
+			return
+		}
+		if r.MaxViews < 0 {
+			http.Error(writer, "max_views must not be negative", http.StatusUnprocessableEntity)
+			return
+		}
+		var expiresIn time.Duration
+		if r.ExpiresIn != "" {
+			if expiresIn, err = time.ParseDuration(r.ExpiresIn); err != nil || expiresIn <= 0 {
+				http.Error(writer, fmt.Sprintf("invalid expires_in %q", r.ExpiresIn), http.StatusUnprocessableEntity)
+				return
+			}
+		}
+
+		note, tokenKey, ok := s.sharedNote(writer, request)
+		if !ok {
+			return
+		}
+		// a link never outlives its note
+		expiresAt := note.ExpiresAt()
+		if expiresIn > 0 && time.Now().Add(expiresIn).Before(expiresAt) {
+			expiresAt = time.Now().Add(expiresIn)
+		}
+		id, err := uuid.NewV4()
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		linkSecret, err := secret.NewToken()
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		wrapped, err := wrapShareKey(linkSecret, tokenKey)
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		link, err := s.Shares.CreateShare(request.Context(), &db.ShareLink{
+			ID:         id,
+			NoteID:     note.ID,
+			Permission: r.Permission,
+			ExpiresAt:  expiresAt,
+			MaxViews:   r.MaxViews,
+			WrappedKey: wrapped,
+		})
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		token, err := s.signShareToken(shareClaims{
+			LinkID:     link.ID,
+			NoteID:     link.NoteID,
+			Permission: link.Permission,
+			ExpiresAt:  link.ExpiresAt.Unix(),
+			Secret:     linkSecret,
+		})
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		linkJson, err := json.Marshal(responseBody{ShareLink: publicShare(link), Token: token})
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		writer.WriteHeader(http.StatusCreated)
+		writer.Write(linkJson)
+	}
+}
+
+func (s *Server) ListShares() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		note, _, ok := s.sharedNote(writer, request)
+		if !ok {
+			return
+		}
+		links, err := s.Shares.ListShares(request.Context(), note.ID)
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if links == nil {
+			links = []*db.ShareLink{}
+		}
+		for i, l := range links {
+			links[i] = publicShare(l)
+		}
+
+		linksJson, err := json.Marshal(links)
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		writer.WriteHeader(http.StatusOK)
+		writer.Write(linksJson)
+	}
+}
+
+func (s *Server) RevokeShare() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		id, err := uuid.FromString(mux.Vars(request)["id"])
+		if err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		note, _, ok := s.sharedNote(writer, request)
+		if !ok {
+			return
+		}
+		revoked, err := s.Shares.RevokeShare(request.Context(), note.ID, id)
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !revoked {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+	}
+}
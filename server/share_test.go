@@ -0,0 +1,143 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/pimka/go-onenote/db"
+	"github.com/pimka/go-onenote/server"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServer_ShareLinks(t *testing.T) {
+	s := createUserServer()
+	s.Shares = db.NewMemoryShareDB()
+	key, err := server.NewShareKey("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.ShareKey = key
+	for _, name := range []string{"pupa", "lupa"} {
+		if resp := postCredentials(s.Register(), `{"username":"`+name+`","password":"lupa-pupa"}`); resp.Code != http.StatusCreated {
+			t.Fatalf("Register returned %d", resp.Code)
+		}
+	}
+
+	router := mux.NewRouter()
+	router.Handle("/note/", s.Authorize("notes.create", s.AddNote())).Methods("POST")
+	router.Handle("/note/{token}", s.Authorize("notes.get", s.GetNote())).Methods("GET")
+	router.Handle("/note/{token}", s.Authorize("notes.update", s.UpdateNote())).Methods("PATCH")
+	router.Handle("/note/{token}/share", s.Authorize("shares.create", s.CreateShare())).Methods("POST")
+	router.Handle("/note/{token}/share", s.Authorize("shares.list", s.ListShares())).Methods("GET")
+	router.Handle("/note/{token}/share/{id}", s.Authorize("shares.revoke", s.RevokeShare())).Methods("DELETE")
+
+	do := func(user, method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		if user != "" {
+			req.SetBasicAuth(user, "lupa-pupa")
+		}
+		respRecoder := httptest.NewRecorder()
+		router.ServeHTTP(respRecoder, req)
+		return respRecoder
+	}
+	var note struct {
+		ID    string `json:"id"`
+		Text  string `json:"text"`
+		Token string `json:"token"`
+	}
+	json.Unmarshal(do("pupa", "POST", "/note/", `{"text":"shared","expiration":10}`).Body.Bytes(), &note)
+	noteToken := note.Token
+
+	share := func(user, body string) (string, string, int) {
+		resp := do(user, "POST", "/note/"+noteToken+"/share", body)
+		var link struct {
+			ID    string `json:"id"`
+			Token string `json:"token"`
+		}
+		json.Unmarshal(resp.Body.Bytes(), &link)
+		return link.ID, link.Token, resp.Code
+	}
+	if _, _, code := share("lupa", `{}`); code != http.StatusNotFound {
+		t.Fatalf("CreateShare by a stranger returned %d", code)
+	}
+	if _, _, code := share("pupa", `{"permission":"write"}`); code != http.StatusUnprocessableEntity {
+		t.Errorf("CreateShare accepted an unknown permission, %d", code)
+	}
+	_, once, code := share("pupa", `{"max_views":1}`)
+	if code != http.StatusCreated || !strings.HasPrefix(once, server.ShareTokenPrefix) {
+		t.Fatalf("CreateShare returned %d", code)
+	}
+	rwID, rw, _ := share("pupa", `{"permission":"read-write","expires_in":"1h"}`)
+
+	if resp := do("", "GET", "/note/"+noteToken, ""); resp.Code != http.StatusUnauthorized {
+		t.Errorf("GetNote without credentials returned %d", resp.Code)
+	}
+	resp := do("", "GET", "/note/"+once, "")
+	json.Unmarshal(resp.Body.Bytes(), &note)
+	if resp.Code != http.StatusOK || note.Text != "shared" {
+		t.Fatalf("GetNote with a share link returned %d", resp.Code)
+	}
+	if resp = do("", "GET", "/note/"+once, ""); resp.Code != http.StatusNotFound {
+		t.Errorf("share link outlived its view limit, %d", resp.Code)
+	}
+	if resp = do("", "PATCH", "/note/"+once, `{"text":"edited"}`); resp.Code != http.StatusForbidden && resp.Code != http.StatusNotFound {
+		t.Errorf("UpdateNote with a used up link returned %d", resp.Code)
+	}
+
+	_, read, _ := share("pupa", `{}`)
+	if resp = do("", "PATCH", "/note/"+read, `{"text":"edited"}`); resp.Code != http.StatusForbidden {
+		t.Errorf("UpdateNote with a read link returned %d", resp.Code)
+	}
+	if resp = do("", "PATCH", "/note/"+rw, `{"text":"edited"}`); resp.Code != http.StatusAccepted {
+		t.Fatalf("UpdateNote with a read-write link returned %d", resp.Code)
+	}
+	json.Unmarshal(do("pupa", "GET", "/note/"+noteToken, "").Body.Bytes(), &note)
+	if note.Text != "edited" {
+		t.Error("read-write link didn't update the note")
+	}
+
+	forged := rw[:len(rw)-2] + "AA"
+	if resp = do("", "GET", "/note/"+forged, ""); resp.Code != http.StatusNotFound {
+		t.Errorf("GetNote accepted a forged link, %d", resp.Code)
+	}
+
+	var links []*db.ShareLink
+	json.Unmarshal(do("pupa", "GET", "/note/"+noteToken+"/share", "").Body.Bytes(), &links)
+	if len(links) != 2 {
+		t.Fatalf("ListShares returned %d links", len(links))
+	}
+	for _, l := range links {
+		if l.WrappedKey != "" {
+			t.Error("ListShares returned a wrapped key")
+		}
+	}
+
+	// the note key stays in the link record, revoking the link revokes it
+	payload, _, _ := strings.Cut(strings.TrimPrefix(read, server.ShareTokenPrefix), ".")
+	data, _ := base64.RawURLEncoding.DecodeString(payload)
+	var claims map[string]interface{}
+	if err = json.Unmarshal(data, &claims); err != nil {
+		t.Fatal(err)
+	}
+	for claim := range claims {
+		if claim != "lid" && claim != "nid" && claim != "perm" && claim != "exp" && claim != "s" {
+			t.Errorf("share link carries the %q claim", claim)
+		}
+	}
+	if resp = do("lupa", "DELETE", "/note/"+noteToken+"/share/"+rwID, ""); resp.Code != http.StatusNotFound {
+		t.Errorf("RevokeShare by a stranger returned %d", resp.Code)
+	}
+	if resp = do("pupa", "DELETE", "/note/"+noteToken+"/share/"+rwID, ""); resp.Code != http.StatusNoContent {
+		t.Fatalf("RevokeShare returned %d", resp.Code)
+	}
+	if resp = do("", "GET", "/note/"+rw, ""); resp.Code != http.StatusNotFound {
+		t.Errorf("revoked link still works, %d", resp.Code)
+	}
+	if resp = do("", "GET", "/note/"+read, ""); resp.Code != http.StatusOK {
+		t.Errorf("revoking one link broke another, %d", resp.Code)
+	}
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"github.com/gofrs/uuid"
+	"github.com/pimka/go-onenote/secret"
+)
+
+const noteKeyInfo = "onenote note text"
+
+// noteToken resolves an access token to the ID of its note and the key the
+// note text is sealed with. The ID is the SHA-256 of the token cut down to the
+// 128 bits of the primary key, so the store never sees the token itself and a
+// dump of it can't be turned back into working links or readable notes.
+func noteToken(token string) (uuid.UUID, []byte, error) {
+	hash, err := secret.HashToken(token)
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
+	uid, err := uuid.FromBytes(hash[:uuid.Size])
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
+	key, err := secret.TokenKey(token, noteKeyInfo)
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
+	return uid, key, nil
+}
+
+func newNoteToken() (string, uuid.UUID, []byte, error) {
+	token, err := secret.NewToken()
+	if err != nil {
+		return "", uuid.Nil, nil, err
+	}
+	uid, key, err := noteToken(token)
+	if err != nil {
+		return "", uuid.Nil, nil, err
+	}
+	return token, uid, key, nil
+}
+
+// sealText encrypts a note text under its token key, and first under the
+// passphrase key for protected notes.
+func sealText(tokenKey, passKey []byte, text string) (string, error) {
+	var err error
+	if passKey != nil {
+		if text, err = secret.Seal(passKey, text); err != nil {
+			return "", err
+		}
+	}
+	return secret.Seal(tokenKey, text)
+}
+
+func openText(tokenKey, passKey []byte, text string) (string, error) {
+	text, err := secret.Open(tokenKey, text)
+	if err != nil || passKey == nil {
+		return text, err
+	}
+	return secret.Open(passKey, text)
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/pimka/go-onenote/auth"
+	"github.com/pimka/go-onenote/db"
+	"github.com/pimka/go-onenote/secret"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// AccessTokenPrefix marks personal access tokens, so they are easy to tell
+// apart from note tokens and to catch with secret scanners.
+const AccessTokenPrefix = "onp_"
+
+func accessTokenHash(token string) (string, error) {
+	if !strings.HasPrefix(token, AccessTokenPrefix) {
+		return "", secret.ErrInvalidToken
+	}
+	hash, err := secret.HashToken(strings.TrimPrefix(token, AccessTokenPrefix))
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// authenticateToken resolves a bearer access token to its principal, nil
+// when the token is unknown, revoked or expired.
+func (s *Server) authenticateToken(request *http.Request, token string) (*auth.Principal, error) {
+	hash, err := accessTokenHash(token)
+	if err != nil {
+		return nil, nil
+	}
+	ctx := request.Context()
+	t, err := s.Tokens.GetTokenByHash(ctx, hash)
+	if err != nil || t == nil {
+		return nil, err
+	}
+	now := time.Now()
+	if t.Expired(now) {
+		return nil, nil
+	}
+	user, err := s.Users.GetUser(ctx, t.UserID)
+	if err != nil || user == nil {
+		return nil, err
+	}
+	if err = s.Tokens.TouchToken(ctx, t.ID, now); err != nil {
+		return nil, err
+	}
+	return &auth.Principal{UserID: user.ID, Username: user.Username, Role: s.userRole(user), Scopes: t.Scopes}, nil
+}
+
+func (s *Server) CreateToken() http.HandlerFunc {
+	type requestBody struct {
+		Name      string   `json:"name"`
+		Scopes    []string `json:"scopes"`
+		ExpiresIn string   `json:"expires_in"`
+	}
+	type responseBody struct {
+		*db.AccessToken
+		Token string `json:"token"`
+	}
+	return func(writer http.ResponseWriter, request *http.Request) {
+		var r requestBody
+		bytes, err := ioutil.ReadAll(request.Body)
+		if err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err = json.Unmarshal(bytes, &r); err != nil {
+			http.Error(writer, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		if r.Name == "" {
+			http.Error(writer, "name is required", http.StatusUnprocessableEntity)
+			return
+		}
+		if len(r.Scopes) == 0 {
+			http.Error(writer, "at least one scope is required", http.StatusUnprocessableEntity)
+			return
+		}
+		for _, scope := range r.Scopes {
+			if !auth.ValidScope(scope) {
+				http.Error(writer, fmt.Sprintf("unknown scope %q", scope), http.StatusUnprocessableEntity)
+				return
+			}
+		}
+		var expiresAt *time.Time
+		if r.ExpiresIn != "" {
+			d, err := time.ParseDuration(r.ExpiresIn)
+			if err != nil || d <= 0 {
+				http.Error(writer, fmt.Sprintf("invalid expires_in %q", r.ExpiresIn), http.StatusUnprocessableEntity)
+				return
+			}
+			at := time.Now().Add(d)
+			expiresAt = &at
+		}
+
+		raw, err := secret.NewToken()
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		token := AccessTokenPrefix + raw
+		hash, err := accessTokenHash(token)
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		id, err := uuid.NewV4()
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		t, err := s.Tokens.CreateToken(request.Context(), &db.AccessToken{
+			ID:        id,
+			UserID:    auth.FromContext(request.Context()).UserID,
+			Name:      r.Name,
+			Hash:      hash,
+			Scopes:    r.Scopes,
+			ExpiresAt: expiresAt,
+		})
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		t.Hash = ""
+
+		tokenJson, err := json.Marshal(responseBody{AccessToken: t, Token: token})
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		writer.WriteHeader(http.StatusCreated)
+		writer.Write(tokenJson)
+	}
+}
+
+func (s *Server) ListTokens() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		tokens, err := s.Tokens.ListTokens(ctx, auth.FromContext(ctx).UserID)
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if tokens == nil {
+			tokens = []*db.AccessToken{}
+		}
+		for _, t := range tokens {
+			t.Hash = ""
+		}
+
+		tokensJson, err := json.Marshal(tokens)
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		writer.WriteHeader(http.StatusOK)
+		writer.Write(tokensJson)
+	}
+}
+
+func (s *Server) RevokeToken() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		id, err := uuid.FromString(mux.Vars(request)["id"])
+		if err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		ctx := request.Context()
+		revoked, err := s.Tokens.RevokeToken(ctx, auth.FromContext(ctx).UserID, id)
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !revoked {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,142 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"github.com/pimka/go-onenote/auth"
+	"github.com/pimka/go-onenote/db"
+	"github.com/pimka/go-onenote/server"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServer_AccessTokens(t *testing.T) {
+	s := createUserServer()
+	tokens := db.NewMemoryTokenDB()
+	s.Tokens = tokens
+	postCredentials(s.Register(), `{"username":"pupa","password":"lupa-pupa"}`)
+
+	router := mux.NewRouter()
+	router.Handle("/user/tokens", s.Authenticate(s.CreateToken())).Methods("POST")
+	router.Handle("/user/tokens/{id}", s.Authenticate(s.RevokeToken())).Methods("DELETE")
+	router.Handle("/read", s.Authorize("notes.list", http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {})))
+	router.Handle("/write", s.Authorize("notes.delete", http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {})))
+
+	do := func(method, path, body, bearer string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		} else {
+			req.SetBasicAuth("pupa", "lupa-pupa")
+		}
+		respRecoder := httptest.NewRecorder()
+		router.ServeHTTP(respRecoder, req)
+		return respRecoder
+	}
+
+	for _, body := range []string{
+		`{"name":"ci","scopes":[]}`,
+		`{"name":"ci","scopes":["notes:delete"]}`,
+		`{"name":"ci","scopes":["notes:read"],"expires_in":"-1h"}`,
+	} {
+		if resp := do("POST", "/user/tokens", body, ""); resp.Code != http.StatusUnprocessableEntity {
+			t.Errorf("CreateToken %s returned %d", body, resp.Code)
+		}
+	}
+
+	resp := do("POST", "/user/tokens", `{"name":"ci","scopes":["notes:read"],"expires_in":"24h"}`, "")
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("CreateToken returned %d", resp.Code)
+	}
+	var created struct {
+		db.AccessToken
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if created.Token == "" || created.Hash != "" || created.ExpiresAt == nil {
+		t.Fatal("CreateToken returned a wrong token")
+	}
+
+	if resp = do("GET", "/read", "", created.Token); resp.Code != http.StatusOK {
+		t.Errorf("read with a notes:read token returned %d", resp.Code)
+	}
+	if resp = do("GET", "/write", "", created.Token); resp.Code != http.StatusForbidden {
+		t.Errorf("write with a notes:read token returned %d", resp.Code)
+	}
+	if resp = do("GET", "/read", "", created.Token+"x"); resp.Code != http.StatusUnauthorized {
+		t.Errorf("read with a broken token returned %d", resp.Code)
+	}
+	if resp = do("GET", "/write", "", ""); resp.Code != http.StatusOK {
+		t.Errorf("write with a password returned %d", resp.Code)
+	}
+
+	list, _ := tokens.ListTokens(context.Background(), created.UserID)
+	if len(list) != 1 || list[0].LastUsed == nil {
+		t.Fatal("Authenticate didn't record the last use")
+	}
+
+	if resp = do("DELETE", fmt.Sprintf("/user/tokens/%s", created.ID), "", ""); resp.Code != http.StatusNoContent {
+		t.Fatalf("RevokeToken returned %d", resp.Code)
+	}
+	if resp = do("GET", "/read", "", created.Token); resp.Code != http.StatusUnauthorized {
+		t.Errorf("read with a revoked token returned %d", resp.Code)
+	}
+}
+
+func TestServer_JWTAuth(t *testing.T) {
+	authenticator, err := server.NewAuthenticator(server.Config{AuthMode: server.AuthModeJWT, JWTSecret: "lupa-pupa"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var principal *auth.Principal
+	s := &server.Server{Authenticator: authenticator}
+	handler := s.Authorize("notes.get", http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		principal = auth.FromContext(request.Context())
+	}))
+
+	do := func(token string) int {
+		req, _ := http.NewRequest("GET", "/note/", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		respRecoder := httptest.NewRecorder()
+		handler.ServeHTTP(respRecoder, req)
+		return respRecoder.Code
+	}
+	sign := func(scope string) string {
+		token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"sub":   "pupa",
+			"scope": scope,
+			"exp":   time.Now().Add(time.Minute).Unix(),
+		}).SignedString([]byte("lupa-pupa"))
+		return token
+	}
+
+	if code := do(""); code != http.StatusUnauthorized {
+		t.Errorf("request without a token returned %d", code)
+	}
+	if code := do("onp_garbage"); code != http.StatusUnauthorized {
+		t.Errorf("request with an invalid token returned %d", code)
+	}
+	if code := do(sign("notes:write")); code != http.StatusForbidden {
+		t.Errorf("request without the scope returned %d", code)
+	}
+	if code := do(sign("notes:read")); code != http.StatusOK || principal == nil || principal.Username != "pupa" {
+		t.Errorf("request with a valid token returned %d", code)
+	}
+
+	if _, err = server.NewAuthenticator(server.Config{AuthMode: "ldap"}); err == nil {
+		t.Error("NewAuthenticator accepted an unknown mode")
+	}
+	if a, err := server.NewAuthenticator(server.Config{AuthMode: server.AuthModeBasic}); err != nil || a != nil {
+		t.Error("basic mode didn't keep the default authenticator")
+	}
+}
@@ -0,0 +1,270 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/pimka/go-onenote/auth"
+	"github.com/pimka/go-onenote/db"
+	"github.com/pimka/go-onenote/secret"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	minPasswordLen = 8
+	maxUsernameLen = 64
+)
+
+type credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type userResponse struct {
+	ID       uuid.UUID `json:"id"`
+	Username string    `json:"username"`
+	Role     string    `json:"role"`
+	Created  time.Time `json:"created"`
+}
+
+func (s *Server) newUserResponse(u *db.User) userResponse {
+	return userResponse{ID: u.ID, Username: u.Username, Role: s.userRole(u), Created: u.Created}
+}
+
+func (s *Server) defaultRole() string {
+	if s.DefaultRole != "" {
+		return s.DefaultRole
+	}
+	return auth.RoleEditor
+}
+
+// userRole returns the role of u, users from before roles get the default.
+func (s *Server) userRole(u *db.User) string {
+	if u.Role != "" {
+		return u.Role
+	}
+	return s.defaultRole()
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+// checkPassword looks the user up and verifies the password. Unknown users
+// are checked against a dummy hash, so both cases take as long and the
+// response time doesn't tell which usernames exist.
+func (s *Server) checkPassword(ctx context.Context, username, password string) (*db.User, error) {
+	user, err := s.Users.GetUserByName(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	hash := ""
+	if user != nil {
+		hash = user.PassHash
+	} else {
+		dummyHashOnce.Do(func() {
+			dummyHash, _, _ = secret.HashPassphrase("")
+		})
+		hash = dummyHash
+	}
+
+	_, ok, err := secret.VerifyPassphrase(hash, password)
+	if err != nil || !ok || user == nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Authenticate accepts HTTP Basic credentials checked against the users
+// store or a personal access token as "Authorization: Bearer", and puts the
+// principal into the request context. Requests without valid credentials
+// stop here with 401.
+func (s *Server) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var principal *auth.Principal
+		var err error
+		if header := request.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+			principal, err = s.authenticateToken(request, strings.TrimPrefix(header, "Bearer "))
+		} else if username, password, ok := request.BasicAuth(); ok {
+			var user *db.User
+			user, err = s.checkPassword(request.Context(), username, password)
+			if user != nil {
+				principal = &auth.Principal{UserID: user.ID, Username: user.Username, Role: s.userRole(user)}
+			}
+		}
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if principal == nil {
+			if request.Header.Get("Authorization") != "" {
+				audit(request.Context(), "rejected credentials")
+			}
+			unauthorized(writer)
+			return
+		}
+
+		ctx := auth.WithPrincipal(request.Context(), principal)
+		next.ServeHTTP(writer, request.WithContext(ctx))
+	})
+}
+
+// authenticate wraps next in the configured authenticator, the users store
+// backed Authenticate by default.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	if s.Authenticator != nil {
+		return s.Authenticator(next)
+	}
+	return s.Authenticate(next)
+}
+
+// identify authenticates requests that come with credentials and lets
+// anonymous ones through without a principal.
+func (s *Server) identify(next http.Handler) http.Handler {
+	authenticated := s.authenticate(next)
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Header.Get("Authorization") == "" {
+			next.ServeHTTP(writer, request)
+			return
+		}
+		authenticated.ServeHTTP(writer, request)
+	})
+}
+
+func unauthorized(writer http.ResponseWriter) {
+	writer.Header().Add("WWW-Authenticate", `Basic realm="Restricted"`)
+	writer.Header().Add("WWW-Authenticate", `Bearer realm="Restricted"`)
+	http.Error(writer, "Unauthorized", http.StatusUnauthorized)
+}
+
+func readCredentials(request *http.Request) (credentials, error) {
+	var c credentials
+	bytes, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(bytes, &c)
+	return c, err
+}
+
+func (s *Server) Register() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		c, err := readCredentials(request)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		if c.Username == "" || len(c.Username) > maxUsernameLen {
+			http.Error(writer, "username must be 1 to 64 characters", http.StatusUnprocessableEntity)
+			return
+		}
+		if len(c.Password) < minPasswordLen {
+			http.Error(writer, "password must be at least 8 characters", http.StatusUnprocessableEntity)
+			return
+		}
+
+		hash, _, err := secret.HashPassphrase(c.Password)
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		uid, err := uuid.NewV4()
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		user, err := s.Users.CreateUser(request.Context(), &db.User{ID: uid, Username: c.Username, PassHash: hash, Role: s.defaultRole()})
+		if err != nil {
+			if err == db.ErrUserExists {
+				http.Error(writer, err.Error(), http.StatusConflict)
+				return
+			}
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		userJson, err := json.Marshal(s.newUserResponse(user))
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		writer.WriteHeader(http.StatusCreated)
+		writer.Write(userJson)
+	}
+}
+
+// Login checks a username and password and returns the user, so clients can
+// validate credentials before they use them for Basic auth.
+func (s *Server) Login() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		c, err := readCredentials(request)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		user, err := s.checkPassword(request.Context(), c.Username, c.Password)
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if user == nil {
+			audit(request.Context(), "failed login of %q", c.Username)
+			http.Error(writer, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		userJson, err := json.Marshal(s.newUserResponse(user))
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		writer.WriteHeader(http.StatusOK)
+		writer.Write(userJson)
+	}
+}
+
+func (s *Server) SetUserRole() http.HandlerFunc {
+	type requestBody struct {
+		Role string `json:"role"`
+	}
+	return func(writer http.ResponseWriter, request *http.Request) {
+		var r requestBody
+		uid, err := uuid.FromString(mux.Vars(request)["id"])
+		if err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		bytes, err := ioutil.ReadAll(request.Body)
+		if err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err = json.Unmarshal(bytes, &r); err != nil {
+			http.Error(writer, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		if !auth.ValidRole(r.Role) {
+			http.Error(writer, fmt.Sprintf("unknown role %q", r.Role), http.StatusUnprocessableEntity)
+			return
+		}
+
+		found, err := s.Users.SetRole(request.Context(), uid, r.Role)
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		audit(request.Context(), "role of user %s set to %s", uid, r.Role)
+		writer.WriteHeader(http.StatusNoContent)
+	}
+}
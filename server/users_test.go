@@ -0,0 +1,89 @@
+package server_test
+
+import (
+	"bytes"
+	"github.com/pimka/go-onenote/auth"
+	"github.com/pimka/go-onenote/db"
+	"github.com/pimka/go-onenote/server"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func createUserServer() *server.Server {
+	s := createServer(db.NewMockDB())
+	s.Users = db.NewMemoryUserDB()
+	return s
+}
+
+func postCredentials(handler http.Handler, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", "/user/", bytes.NewBufferString(body))
+	respRecoder := httptest.NewRecorder()
+	handler.ServeHTTP(respRecoder, req)
+	return respRecoder
+}
+
+func TestServer_Register(t *testing.T) {
+	s := createUserServer()
+
+	for _, c := range []struct {
+		body string
+		code int
+	}{
+		{`{"username":"pupa","password":"lupa-pupa"}`, http.StatusCreated},
+		{`{"username":"pupa","password":"lupa-pupa"}`, http.StatusConflict},
+		{`{"username":"lupa","password":"short"}`, http.StatusUnprocessableEntity},
+		{`{"username":"","password":"lupa-pupa"}`, http.StatusUnprocessableEntity},
+	} {
+		if resp := postCredentials(s.Register(), c.body); resp.Code != c.code {
+			t.Errorf("Register %s returned %d, want %d", c.body, resp.Code, c.code)
+		}
+	}
+
+	if resp := postCredentials(s.Login(), `{"username":"pupa","password":"lupa-pupa"}`); resp.Code != http.StatusOK {
+		t.Errorf("Login returned %d", resp.Code)
+	}
+	if resp := postCredentials(s.Login(), `{"username":"pupa","password":"pupa-lupa"}`); resp.Code != http.StatusUnauthorized {
+		t.Errorf("Login with a wrong password returned %d", resp.Code)
+	}
+	if resp := postCredentials(s.Login(), `{"username":"lupa","password":"lupa-pupa"}`); resp.Code != http.StatusUnauthorized {
+		t.Errorf("Login of an unknown user returned %d", resp.Code)
+	}
+}
+
+func TestServer_Authenticate(t *testing.T) {
+	s := createUserServer()
+	postCredentials(s.Register(), `{"username":"pupa","password":"lupa-pupa"}`)
+
+	var principal *auth.Principal
+	handler := s.Authenticate(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		principal = auth.FromContext(request.Context())
+	}))
+
+	for _, c := range []struct {
+		user, pass string
+		code       int
+	}{
+		{"", "", http.StatusUnauthorized},
+		{"pupa", "pupa", http.StatusUnauthorized},
+		{"lupa", "lupa-pupa", http.StatusUnauthorized},
+		{"pupa", "lupa-pupa", http.StatusOK},
+	} {
+		principal = nil
+		req, _ := http.NewRequest("GET", "/note/", nil)
+		if c.user != "" {
+			req.SetBasicAuth(c.user, c.pass)
+		}
+		respRecoder := httptest.NewRecorder()
+		handler.ServeHTTP(respRecoder, req)
+		if respRecoder.Code != c.code {
+			t.Errorf("Authenticate %s:%s returned %d, want %d", c.user, c.pass, respRecoder.Code, c.code)
+		}
+		if c.code != http.StatusOK && principal != nil {
+			t.Error("Authenticate called next after a failure")
+		}
+	}
+	if principal == nil || principal.Username != "pupa" {
+		t.Error("Authenticate didn't put the principal into the context")
+	}
+}